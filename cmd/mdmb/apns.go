@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/jessepeterson/mdmb/internal/apns"
+	"github.com/jessepeterson/mdmb/internal/device"
+	"github.com/jessepeterson/mdmb/scepclient"
+)
+
+// pushConnector connects devices to MDM in response to push notifications.
+type pushConnector struct {
+	*connector
+	uuids map[string]bool // restrict to these UDIDs if non-empty
+}
+
+func newPushConnector(c *connector, uuids []string) *pushConnector {
+	p := &pushConnector{
+		connector: c,
+		uuids:     make(map[string]bool),
+	}
+	for _, u := range uuids {
+		p.uuids[u] = true
+	}
+	return p
+}
+
+func (p *pushConnector) notify(_ context.Context, n *apns.Notification) error {
+	dev, err := device.LoadByPushToken(n.Token, p.rctx.DB)
+	if errors.Is(err, device.ErrPushTokenNotFound) {
+		return apns.ErrBadDeviceToken
+	} else if err != nil {
+		return err
+	}
+	if len(p.uuids) > 0 && !p.uuids[dev.UDID] {
+		return apns.ErrBadDeviceToken
+	}
+
+	client, err := dev.MDMClient()
+	if err != nil {
+		return fmt.Errorf("%w: %v", apns.ErrUnregistered, err)
+	}
+	if client.MDMPayload.Topic != n.Topic {
+		return apns.ErrTopicMismatch
+	}
+	if dev.PushMagic != n.PushMagic {
+		// a real device silently ignores mismatched push magic
+		log.Printf("push notification %s for device %s: push magic mismatch", n.ID, dev.UDID)
+		return nil
+	}
+
+	p.connect(dev.UDID)
+	return nil
+}
+
+func newAPNsHTTPServer(addr, certFile, keyFile string, handler http.Handler) (*http.Server, error) {
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+		TLSConfig: &tls.Config{
+			// HTTP/2 is required by the APNs provider API
+			NextProtos: []string{"h2", "http/1.1"},
+		},
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		srv.TLSConfig.Certificates = []tls.Certificate{cert}
+		return srv, nil
+	}
+	cert, key, err := scepclient.SimpleSelfSignedRSAKeypair("localhost", 365)
+	if err != nil {
+		return nil, fmt.Errorf("generating self-signed certificate: %w", err)
+	}
+	srv.TLSConfig.Certificates = []tls.Certificate{{
+		Certificate: [][]byte{cert.Raw},
+		PrivateKey:  key,
+		Leaf:        cert,
+	}}
+	return srv, nil
+}
+
+func apnsServer(name string, args []string, rctx RunContext, usage func()) {
+	f := flag.NewFlagSet(name, flag.ExitOnError)
+	var (
+		listen   = f.String("listen", ":2197", "HTTP/2 (TLS) listen address")
+		certFile = f.String("cert", "", "TLS certificate file (self-signed if not supplied)")
+		keyFile  = f.String("key", "", "TLS private key file")
+	)
+	setSubCommandFlagSetUsage(f, usage)
+	f.Parse(args)
+
+	c := newConnector(withPhaseMetrics(rctx.Context, rctx.Metrics, name), rctx, 0)
+	pc := newPushConnector(c, rctx.UUIDs)
+	srv, err := newAPNsHTTPServer(*listen, *certFile, *keyFile, apns.New(pc.notify))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("starting APNs server on %s\n", *listen)
+	err = serveUntilDone(rctx.Context, srv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	c.wait()
+}
+
+// serveUntilDone serves TLS HTTP requests until ctx is done.
+func serveUntilDone(ctx context.Context, srv *http.Server) error {
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+	err := srv.ListenAndServeTLS("", "")
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
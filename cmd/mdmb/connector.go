@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// connector connects devices to MDM on request. Like a real device,
+// requests received while a device is already connecting are coalesced
+// into a single additional connect.
+type connector struct {
+	ctx  context.Context
+	rctx RunContext
+
+	// limits concurrent connects if non-nil
+	sem chan struct{}
+
+	// called after every connect if non-nil
+	result func(udid string, d time.Duration, err error)
+
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	pending map[string]bool // UDID in-flight; true if another connect is wanted
+}
+
+func newConnector(ctx context.Context, rctx RunContext, workers int) *connector {
+	c := &connector{
+		ctx:     ctx,
+		rctx:    rctx,
+		pending: make(map[string]bool),
+	}
+	if workers > 0 {
+		c.sem = make(chan struct{}, workers)
+	}
+	return c
+}
+
+// connect starts connecting the device to MDM or queues another connect
+// if the device is already connecting.
+func (c *connector) connect(udid string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ctx.Err() != nil {
+		return
+	}
+	if _, ok := c.pending[udid]; ok {
+		c.pending[udid] = true
+		return
+	}
+	c.pending[udid] = false
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		for {
+			c.connectDevice(udid)
+			c.mu.Lock()
+			if !c.pending[udid] || c.ctx.Err() != nil {
+				delete(c.pending, udid)
+				c.mu.Unlock()
+				return
+			}
+			c.pending[udid] = false
+			c.mu.Unlock()
+		}
+	}()
+}
+
+func (c *connector) connectDevice(udid string) {
+	if c.sem != nil {
+		select {
+		case c.sem <- struct{}{}:
+			defer func() { <-c.sem }()
+		case <-c.ctx.Done():
+			return
+		}
+	}
+	started := time.Now()
+	err := connectDeviceUDID(c.ctx, c.rctx, udid)
+	d := time.Since(started)
+	if c.ctx.Err() != nil {
+		// shutting down
+		return
+	}
+	if err != nil {
+		log.Println(fmt.Errorf("device connect for device %s: %w", udid, err))
+	} else {
+		fmt.Printf("device connect for device %s took %s\n", udid, d)
+	}
+	contextPhaseMetrics(c.ctx).operation("device connect", d, err)
+	if c.result != nil {
+		c.result(udid, d, err)
+	}
+}
+
+// wait waits for all in-flight connects to finish.
+func (c *connector) wait() {
+	c.wg.Wait()
+}
+
+func connectDeviceUDID(ctx context.Context, rctx RunContext, udid string) error {
+	// load the device fresh as commands may have changed it
+	dev, err := rctx.LoadDevice(udid)
+	if err != nil {
+		return err
+	}
+	client, err := dev.MDMClient()
+	if err != nil {
+		return err
+	}
+	return client.Connect(ctx)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// ddmSyncOp synchronizes the DDM declarations of a device.
+func ddmSyncOp(rctx RunContext) deviceOp {
+	return func(ctx context.Context, udid string) error {
+		dev, err := rctx.LoadDevice(udid)
+		if err != nil {
+			return err
+		}
+		client, err := dev.MDMClient()
+		if err != nil {
+			return err
+		}
+		return client.SyncDeclarations(ctx, nil)
+	}
+}
+
+func devicesDDMSync(name string, args []string, rctx RunContext, usage func()) {
+	f := flag.NewFlagSet(name, flag.ExitOnError)
+	var (
+		workers    = f.Int("w", 1, "number of workers (concurrency)")
+		reportOpts = &reportOptions{}
+		limits     = newThresholds()
+	)
+	reportOpts.addFlags(f)
+	limits.addFlags(f)
+	setSubCommandFlagSetUsage(f, usage)
+	f.Parse(args)
+
+	reportOpts.validateFlags(f)
+
+	err := checkDeviceUUIDs(rctx, false, name)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	runDeviceOpBenchmark(rctx, name, "DDM sync", &benchReport{
+		Operation: "ddm-sync",
+		Devices:   len(rctx.UUIDs),
+		Workers:   *workers,
+	}, eachDevice(rctx.UUIDs, *workers, "DDM sync", ddmSyncOp(rctx)), reportOpts, limits)
+}
+
+// statusItemsValue is a flag.Value of DDM status items given as
+// key=value where value is JSON or else a string. An empty value
+// removes the item.
+type statusItemsValue map[string]interface{}
+
+func (v statusItemsValue) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i < 1 {
+		return fmt.Errorf("status item must be key=value: %q", s)
+	}
+	key, value := s[:i], s[i+1:]
+	if value == "" {
+		v[key] = nil
+		return nil
+	}
+	var jv interface{}
+	if err := json.Unmarshal([]byte(value), &jv); err != nil {
+		jv = value
+	}
+	v[key] = jv
+	return nil
+}
+
+func (v statusItemsValue) String() string {
+	var items []string
+	for k, iv := range v {
+		items = append(items, fmt.Sprintf("%s=%v", k, iv))
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+// ddmStatusOp sets the status items of a device (if any) and sends a
+// DDM status report.
+func ddmStatusOp(rctx RunContext, items map[string]interface{}, full bool) deviceOp {
+	return func(ctx context.Context, udid string) error {
+		dev, err := rctx.LoadDevice(udid)
+		if err != nil {
+			return err
+		}
+		if len(items) > 0 {
+			if err = dev.SetStatusItems(items); err != nil {
+				return err
+			}
+		}
+		client, err := dev.MDMClient()
+		if err != nil {
+			return err
+		}
+		return client.SendStatusReport(ctx, full)
+	}
+}
+
+func devicesDDMStatus(name string, args []string, rctx RunContext, usage func()) {
+	f := flag.NewFlagSet(name, flag.ExitOnError)
+	var (
+		items      = statusItemsValue{}
+		full       = f.Bool("full", false, "send full status reports (default only changed status items)")
+		workers    = f.Int("w", 1, "number of workers (concurrency)")
+		reportOpts = &reportOptions{}
+		limits     = newThresholds()
+	)
+	f.Var(items, "item", "set status item as key=value (value is JSON or a string; empty removes it); may be repeated")
+	reportOpts.addFlags(f)
+	limits.addFlags(f)
+	setSubCommandFlagSetUsage(f, usage)
+	f.Parse(args)
+
+	reportOpts.validateFlags(f)
+
+	err := checkDeviceUUIDs(rctx, false, name)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	runDeviceOpBenchmark(rctx, name, "DDM status report", &benchReport{
+		Operation: "ddm-status",
+		Devices:   len(rctx.UUIDs),
+		Workers:   *workers,
+	}, eachDevice(rctx.UUIDs, *workers, "DDM status report", ddmStatusOp(rctx, items, *full)), reportOpts, limits)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jessepeterson/mdmb/protocol"
+)
+
+// httpClientOptions configure the HTTP client of the MDM transport and
+// SCEP client of devices.
+type httpClientOptions struct {
+	caFiles  string // comma-separated
+	insecure bool
+	timeout  time.Duration
+	proxy    string
+	http2    bool
+
+	conns     string // "shared" or "device"
+	keepAlive bool
+}
+
+func (o *httpClientOptions) addFlags(f *flag.FlagSet) {
+	f.StringVar(&o.caFiles, "ca", "", "comma-separated list of additional trusted CA certificate files (PEM) for MDM and SCEP")
+	f.BoolVar(&o.insecure, "insecure", false, "skip TLS server certificate verification for MDM and SCEP")
+	f.DurationVar(&o.timeout, "http-timeout", 0, "timeout of MDM and SCEP HTTP requests (0 for none)")
+	f.StringVar(&o.proxy, "http-proxy", "", "HTTP proxy URL for MDM and SCEP (default from environment)")
+	f.BoolVar(&o.http2, "http2", true, "use HTTP/2 for MDM and SCEP when supported by the server")
+	f.StringVar(&o.conns, "http-conns", "shared", "HTTP connections of devices: shared (one pool for all devices) or device (a pool per device, like real devices)")
+	f.BoolVar(&o.keepAlive, "http-keepalive", true, "reuse HTTP connections between requests")
+}
+
+// rootCAs returns the system roots with the configured CA files added.
+func (o *httpClientOptions) rootCAs() (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	for _, path := range strings.Split(o.caFiles, ",") {
+		if path == "" {
+			continue
+		}
+		pemBytes, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pemBytes) {
+			return nil, fmt.Errorf("no certificates found in CA file: %s", path)
+		}
+	}
+	return pool, nil
+}
+
+// transport returns a new HTTP transport with the configured options.
+func (o *httpClientOptions) transport() (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{InsecureSkipVerify: o.insecure}
+	if o.caFiles != "" {
+		var err error
+		if t.TLSClientConfig.RootCAs, err = o.rootCAs(); err != nil {
+			return nil, err
+		}
+	}
+	if o.proxy != "" {
+		proxyURL, err := url.Parse(o.proxy)
+		if err != nil {
+			return nil, fmt.Errorf("parsing proxy URL: %w", err)
+		}
+		if proxyURL.Scheme == "" || proxyURL.Host == "" {
+			return nil, errors.New("proxy URL must include scheme and host")
+		}
+		t.Proxy = http.ProxyURL(proxyURL)
+	}
+	t.DisableKeepAlives = !o.keepAlive
+	if !o.http2 {
+		// a non-nil, empty map disables HTTP/2
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	return t, nil
+}
+
+// clients returns the HTTP clients of devices with the configured options.
+func (o *httpClientOptions) clients() (*httpClients, error) {
+	if o.conns != "shared" && o.conns != "device" {
+		return nil, fmt.Errorf("invalid HTTP connections mode: %q", o.conns)
+	}
+	t, err := o.transport()
+	if err != nil {
+		return nil, err
+	}
+	c := &httpClients{timeout: o.timeout}
+	if o.conns == "device" {
+		c.base = t
+		c.devices = make(map[string]*http.Client)
+	} else {
+		c.shared = &http.Client{Transport: t, Timeout: o.timeout}
+	}
+	return c, nil
+}
+
+// httpClients provides the HTTP clients of devices. Either all devices
+// share a single client (and its connection pool) or each device gets
+// its own client to model the connection churn of real devices.
+// It is safe for concurrent use.
+type httpClients struct {
+	timeout time.Duration
+	shared  *http.Client
+
+	base    *http.Transport // cloned for each device
+	mu      sync.Mutex
+	devices map[string]*http.Client
+	// clients presenting the device identity as TLS client
+	// certificate by device
+	clientCert map[string]*http.Client
+}
+
+// client returns the HTTP client of the device with udid.
+func (c *httpClients) client(udid string) *http.Client {
+	if c.shared != nil {
+		return c.shared
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	client, ok := c.devices[udid]
+	if !ok {
+		client = &http.Client{Transport: c.base.Clone(), Timeout: c.timeout}
+		c.devices[udid] = client
+	}
+	return client
+}
+
+// clientCertClient returns the HTTP client of the device with udid for
+// MDM requests presenting the device identity as TLS client
+// certificate. Connections are bound to the identity so each device
+// gets its own client (and connection pool) but it is reused across
+// device loads.
+func (c *httpClients) clientCertClient(udid string) *http.Client {
+	client := c.client(udid)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.clientCert == nil {
+		c.clientCert = make(map[string]*http.Client)
+	}
+	cc, ok := c.clientCert[udid]
+	if !ok {
+		var err error
+		if cc, err = protocol.NewClientCertificateClient(client); err != nil {
+			return nil
+		}
+		c.clientCert[udid] = cc
+	}
+	return cc
+}
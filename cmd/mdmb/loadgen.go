@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// loadStage is a period of constant or linearly ramping arrival rate.
+type loadStage struct {
+	Duration time.Duration
+	From     float64 // per second
+	To       float64 // per second
+}
+
+func (ls loadStage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		Duration float64 `json:"duration_seconds"`
+		From     float64 `json:"from"`
+		To       float64 `json:"to"`
+	}{ls.Duration.Seconds(), ls.From, ls.To})
+}
+
+// parseStages parses load stages in the form "duration:rate" or
+// "duration:from-to" separated by commas. For example
+// "30s:0-100,5m:100,30s:100-0" ramps up to 100 connects per second over
+// 30 seconds, holds for 5 minutes, then ramps down again.
+func parseStages(s string) ([]loadStage, error) {
+	var stages []loadStage
+	for _, stageStr := range strings.Split(s, ",") {
+		split := strings.SplitN(strings.TrimSpace(stageStr), ":", 2)
+		if len(split) != 2 {
+			return nil, fmt.Errorf("invalid stage: %q", stageStr)
+		}
+		var (
+			stage loadStage
+			err   error
+		)
+		stage.Duration, err = time.ParseDuration(split[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid stage duration: %w", err)
+		}
+		rates := strings.SplitN(split[1], "-", 2)
+		stage.From, err = strconv.ParseFloat(rates[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stage rate: %w", err)
+		}
+		stage.To = stage.From
+		if len(rates) > 1 {
+			stage.To, err = strconv.ParseFloat(rates[1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid stage rate: %w", err)
+			}
+		}
+		if stage.Duration <= 0 || stage.From < 0 || stage.To < 0 {
+			return nil, fmt.Errorf("invalid stage: %q", stageStr)
+		}
+		stages = append(stages, stage)
+	}
+	return stages, nil
+}
+
+// arrivals returns the scheduled arrival offsets for the stages.
+func arrivals(stages []loadStage) (offsets []time.Duration) {
+	var stageStart time.Duration
+	for _, stage := range stages {
+		// the expected number of arrivals by time t into a stage is the
+		// integral of the (linear) rate: a*t + b*t^2/2
+		a := stage.From
+		b := (stage.To - stage.From) / stage.Duration.Seconds()
+		total := a*stage.Duration.Seconds() + b*math.Pow(stage.Duration.Seconds(), 2)/2
+		for k := 1; float64(k) <= total; k++ {
+			var t float64
+			if b == 0 {
+				t = float64(k) / a
+			} else {
+				t = (-a + math.Sqrt(a*a+2*b*float64(k))) / b
+			}
+			offsets = append(offsets, stageStart+time.Duration(t*float64(time.Second)))
+		}
+		stageStart += stage.Duration
+	}
+	return
+}
+
+// loadReport reports on the scheduling of an open-model load run.
+type loadReport struct {
+	Stages      []loadStage `json:"stages"`
+	Scheduled   int         `json:"scheduled"`
+	Late        int         `json:"late"`    // waited for a free worker
+	Dropped     int         `json:"dropped"` // waited longer than max lateness
+	MaxLateness float64     `json:"max_lateness_ms"`
+}
+
+func (lr *loadReport) print(out io.Writer) {
+	w := tabwriter.NewWriter(out, 4, 4, 4, ' ', 0)
+	fmt.Fprintf(w, "Scheduled MDM connects\t%d\n", lr.Scheduled)
+	fmt.Fprintf(w, "Late MDM connects\t%d\n", lr.Late)
+	fmt.Fprintf(w, "Dropped MDM connects\t%d\n", lr.Dropped)
+	fmt.Fprintf(w, "Max lateness\t%s\n", time.Duration(lr.MaxLateness*float64(time.Millisecond)))
+	w.Flush()
+}
+
+// startConnectLoad connects devices at the arrival rates of stages
+// using up to workers concurrent connects. Arrivals that can't start
+// within maxLateness of their scheduled time are dropped.
+func startConnectLoad(ctx context.Context, cwds []*ConnectWorkerData, stages []loadStage, workers int, maxLateness time.Duration, reqLog requestLog, progress io.Writer) (*statsSummary, *loadReport) {
+	offsets := arrivals(stages)
+	lr := &loadReport{Stages: stages, Scheduled: len(offsets)}
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		maxSeen time.Duration
+		sem     = make(chan struct{}, workers)
+		st      = newStats()
+	)
+	fmt.Fprintf(progress, "starting up to %d workers for %d scheduled connects of %d devices\n", workers, len(offsets), len(cwds))
+	if len(cwds) < 1 {
+		return st.summary(), lr
+	}
+	start := time.Now()
+	for i, offset := range offsets {
+		scheduled := start.Add(offset)
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Until(scheduled)):
+		}
+		if ctx.Err() != nil {
+			lr.Scheduled = i
+			break
+		}
+		cwd := cwds[i%len(cwds)]
+		iteration := i/len(cwds) + 1
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			late := false
+			select {
+			case sem <- struct{}{}:
+			default:
+				late = true
+				deadline := time.NewTimer(time.Until(scheduled.Add(maxLateness)))
+				defer deadline.Stop()
+				select {
+				case sem <- struct{}{}:
+				case <-deadline.C:
+					mu.Lock()
+					lr.Dropped++
+					mu.Unlock()
+					return
+				case <-ctx.Done():
+					return
+				}
+			}
+			defer func() { <-sem }()
+			lateness := time.Since(scheduled)
+			mu.Lock()
+			if late {
+				lr.Late++
+			}
+			if lateness > maxSeen {
+				maxSeen = lateness
+			}
+			mu.Unlock()
+
+			recordedConnectWork(ctx, cwd, iteration, st, reqLog, progress)
+		}()
+	}
+	wg.Wait()
+	st.stop()
+	fmt.Fprint(progress, "\n\n")
+
+	lr.MaxLateness = durationMs(maxSeen)
+	return st.summary(), lr
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestArrivals(t *testing.T) {
+	stages, err := parseStages("10s:0-10,10s:10,10s:10-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	offsets := arrivals(stages)
+	// 50 ramping up, 100 steady, 50 ramping down
+	if have, want := len(offsets), 200; have != want {
+		t.Fatalf("arrivals: have %d, want %d", have, want)
+	}
+	for i := 1; i < len(offsets); i++ {
+		if offsets[i] < offsets[i-1] {
+			t.Fatalf("arrival %d out of order", i)
+		}
+	}
+	if last := offsets[len(offsets)-1]; last > 30*time.Second {
+		t.Errorf("last arrival after end of stages: %s", last)
+	}
+	if have, want := offsets[50], 10*time.Second+100*time.Millisecond; have != want {
+		t.Errorf("first steady arrival: have %s, want %s", have, want)
+	}
+}
+
+func TestParseStagesInvalid(t *testing.T) {
+	for _, s := range []string{"", "10s", "10s:", "x:10", "10s:-5", "0s:10", "10s:a-b"} {
+		if _, err := parseStages(s); err == nil {
+			t.Errorf("expected error parsing %q", s)
+		}
+	}
+}
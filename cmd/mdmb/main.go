@@ -3,14 +3,18 @@ package main
 import (
 	"bufio"
 	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	mathrand "math/rand"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"text/tabwriter"
 	"time"
 
@@ -44,6 +48,27 @@ type RunContext struct {
 	// device UUIDs (UDIDs)
 	UUIDs []string
 	Bag   DeviceBag
+	// Prometheus metrics; nil if not enabled
+	Metrics *metrics
+	// HTTP clients for device MDM and SCEP requests
+	HTTPClients *httpClients
+	// fleet-wide MDM command response policies
+	ResponsePolicies []device.ResponsePolicy
+}
+
+// LoadDevice loads a device configured with the global settings.
+func (rctx RunContext) LoadDevice(udid string) (*device.Device, error) {
+	var opts []device.Option
+	if rctx.HTTPClients != nil {
+		opts = append(opts, device.WithHTTPClient(rctx.HTTPClients.client(udid)))
+		if cc := rctx.HTTPClients.clientCertClient(udid); cc != nil {
+			opts = append(opts, device.WithClientCertHTTPClient(cc))
+		}
+	}
+	if len(rctx.ResponsePolicies) > 0 {
+		opts = append(opts, device.WithResponsePolicies(rctx.ResponsePolicies))
+	}
+	return device.Load(udid, rctx.DB, opts...)
 }
 
 type devicePkgBag struct {
@@ -60,18 +85,31 @@ func main() {
 		{"devices-list", "list created devices", devicesList},
 		{"devices-create", "create new devices", devicesCreate},
 		{"devices-connect", "devices connect to MDM", devicesConnect},
+		{"devices-run", "keep devices running, connecting to MDM periodically and on push", devicesRun},
 		{"devices-tokenupdate", "send another tokenupdate to MDM server", devicesTokenUpdate},
 		{"devices-profiles-list", "list device profiles", devicesProfilesList},
 		{"devices-profiles-install", "install profiles onto device (i.e. enroll)", devicesProfilesInstall},
 		{"devices-profiles-remove", "remove profiles from device", devicesProfilesRemove},
+		{"devices-security", "set device security posture (for SecurityInfo)", devicesSecurity},
+		{"devices-ddm-sync", "synchronize DDM declarations with MDM server", devicesDDMSync},
+		{"devices-ddm-status", "send DDM status reports to MDM server", devicesDDMStatus},
+		{"devices-response-policy", "set MDM command response policies of devices (NotNow, Error, delay, drop)", devicesResponsePolicy},
+		{"devices-os-updates", "add OS updates available to devices (for AvailableOSUpdates)", devicesOSUpdates},
 		{"devices-mdm-signature", "Print Mdm-Signature header for device", devicesMdmSignature},
+		{"apns-server", "APNs stand-in server that connects pushed devices", apnsServer},
+		{"run", "run a multi-phase benchmark scenario file", runScenario},
 		{"version", "display version", versionSubCmd},
 	}
 	f := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 	var (
 		dbPath = f.String("db", "mdmb.db", "mdmb database file path")
 		uuids  = f.String("uuids", "", "comma-separated list of device UUIDs, '-' to read from stdin, or 'all' for all devices")
+
+		metricsListen = f.String("metrics-listen", "", "HTTP listen address to serve Prometheus metrics on /metrics (e.g. :9100)")
+		policiesPath  = f.String("response-policies", "", "JSON file of MDM command response policies for all devices (after per-device policies)")
+		httpOpts      = &httpClientOptions{}
 	)
+	httpOpts.addFlags(f)
 	f.Usage = func() {
 		fmt.Fprintf(f.Output(), "%s [flags] <subcommand> [flags]\n", f.Name())
 		fmt.Fprint(f.Output(), "\nFlags:\n")
@@ -99,12 +137,32 @@ func main() {
 
 	mathrand.Seed(time.Now().UnixNano())
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	rctx := RunContext{
-		Context: context.Background(),
+		Context: ctx,
 		DB:      db,
 		Bag:     &devicePkgBag{db: db},
 	}
 
+	rctx.HTTPClients, err = httpOpts.clients()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *policiesPath != "" {
+		rctx.ResponsePolicies, err = loadResponsePolicies(*policiesPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *metricsListen != "" {
+		rctx.Metrics = newMetrics()
+		serveMetrics(*metricsListen, rctx.Metrics)
+	}
+
 	if *uuids != "" {
 		if *uuids == "all" {
 			var err error
@@ -157,8 +215,13 @@ func checkDeviceUUIDs(rctx RunContext, requireEmpty bool, subCmdName string) err
 func devicesProfilesInstall(name string, args []string, rctx RunContext, usage func()) {
 	f := flag.NewFlagSet(name, flag.ExitOnError)
 	var (
-		file = f.String("f", "", "profile to install")
+		file       = f.String("f", "", "profile to install")
+		workers    = f.Int("w", 1, "number of workers (concurrency)")
+		reportOpts = &reportOptions{}
+		limits     = newThresholds()
 	)
+	reportOpts.addFlags(f)
+	limits.addFlags(f)
 	setSubCommandFlagSetUsage(f, usage)
 	f.Parse(args)
 
@@ -167,6 +230,7 @@ func devicesProfilesInstall(name string, args []string, rctx RunContext, usage f
 		f.Usage()
 		os.Exit(2)
 	}
+	reportOpts.validateFlags(f)
 
 	ep, err := ioutil.ReadFile(*file)
 	if err != nil {
@@ -178,20 +242,19 @@ func devicesProfilesInstall(name string, args []string, rctx RunContext, usage f
 		log.Fatal(err)
 	}
 
-	for _, u := range rctx.UUIDs {
-		fmt.Println(u)
-		dev, err := device.Load(u, rctx.DB)
-		if err != nil {
-			log.Println(err)
-			continue
-		}
-
-		err = dev.InstallProfile(rctx.Context, ep)
+	op := func(ctx context.Context, udid string) error {
+		dev, err := rctx.LoadDevice(udid)
 		if err != nil {
-			log.Println(err)
-			continue
+			return err
 		}
+		return dev.InstallProfile(ctx, ep)
 	}
+
+	runDeviceOpBenchmark(rctx, name, "profile install", &benchReport{
+		Operation: "install-profile",
+		Devices:   len(rctx.UUIDs),
+		Workers:   *workers,
+	}, eachDevice(rctx.UUIDs, *workers, "profile install", op), reportOpts, limits)
 }
 
 func devicesList(name string, args []string, rctx RunContext, usage func()) {
@@ -212,7 +275,7 @@ func devicesList(name string, args []string, rctx RunContext, usage func()) {
 
 	for _, v := range uuids {
 		if *showSerials {
-			dev, err := device.Load(v, rctx.DB)
+			dev, err := rctx.LoadDevice(v)
 			if err != nil {
 				log.Println(err)
 				continue
@@ -224,17 +287,76 @@ func devicesList(name string, args []string, rctx RunContext, usage func()) {
 	}
 }
 
+// deviceAttrs are optional attributes of newly created devices.
+type deviceAttrs struct {
+	BuildVersion string `json:"build_version"`
+	OSVersion    string `json:"os_version"`
+	ProductName  string `json:"product_name"`
+
+	// app catalog template file (JSON)
+	Apps string `json:"apps"`
+
+	apps []device.App
+}
+
+// loadApps reads the app catalog template. Without one new devices get
+// the default apps.
+func (a *deviceAttrs) loadApps() error {
+	if a.Apps == "" {
+		a.apps = device.DefaultApps
+		return nil
+	}
+	b, err := os.ReadFile(a.Apps)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(b, &a.apps); err != nil {
+		return fmt.Errorf("parsing app catalog: %w", err)
+	}
+	return nil
+}
+
+// createDevice creates and saves a new device with the apps of attrs.
+func createDevice(db *bolt.DB, attrs *deviceAttrs) (*device.Device, error) {
+	d := device.New("", db)
+	if attrs.BuildVersion != "" {
+		d.BuildVersion = attrs.BuildVersion
+	}
+	if attrs.OSVersion != "" {
+		d.OSVersion = attrs.OSVersion
+	}
+	if attrs.ProductName != "" {
+		d.ProductName = attrs.ProductName
+	}
+	err := d.Save()
+	if err != nil {
+		return d, err
+	}
+	for i := range attrs.apps {
+		if err = d.InstallApp(&attrs.apps[i]); err != nil {
+			return d, err
+		}
+	}
+	return d, nil
+}
+
 func devicesCreate(name string, args []string, rctx RunContext, usage func()) {
 	f := flag.NewFlagSet(name, flag.ExitOnError)
 	var (
 		number = f.Int("n", 1, "number of devices")
-		buildVersion = f.String("build-version", "", "build version (e.g. 24E263)")
-		osVersion    = f.String("os-version", "", "OS version (e.g. 15.4)")
-		productName  = f.String("product-name", "", "product name (e.g. Mac16,10)")
+		attrs  = &deviceAttrs{}
 	)
+	f.StringVar(&attrs.BuildVersion, "build-version", "", "build version (e.g. 24E263)")
+	f.StringVar(&attrs.OSVersion, "os-version", "", "OS version (e.g. 15.4)")
+	f.StringVar(&attrs.ProductName, "product-name", "", "product name (e.g. Mac16,10)")
+	f.StringVar(&attrs.Apps, "apps", "", "app catalog template file (JSON) of installed apps (default a few Apple apps)")
 	setSubCommandFlagSetUsage(f, usage)
 	f.Parse(args)
 
+	if err := attrs.loadApps(); err != nil {
+		log.Fatal(err)
+	}
+
 	err := checkDeviceUUIDs(rctx, true, name)
 	if err != nil {
 		log.Fatal(err)
@@ -242,17 +364,7 @@ func devicesCreate(name string, args []string, rctx RunContext, usage func()) {
 
 	fmt.Printf("creating %d device(s)\n", *number)
 	for i := 0; i < *number; i++ {
-		d := device.New("", rctx.DB)
-		if *buildVersion != "" {
-			d.BuildVersion = *buildVersion
-		}
-		if *osVersion != "" {
-			d.OSVersion = *osVersion
-		}
-		if *productName != "" {
-			d.ProductName = *productName
-		}
-		err := d.Save()
+		d, err := createDevice(rctx.DB, attrs)
 		if err != nil {
 			log.Fatal(err)
 			continue
@@ -279,7 +391,7 @@ func devicesTokenUpdate(name string, args []string, rctx RunContext, usage func(
 	for _, u := range rctx.UUIDs {
 		fmt.Println(u)
 
-		dev, err := device.Load(u, rctx.DB)
+		dev, err := rctx.LoadDevice(u)
 		if err != nil {
 			log.Println(err)
 			continue
@@ -301,39 +413,56 @@ func devicesTokenUpdate(name string, args []string, rctx RunContext, usage func(
 func devicesConnect(name string, args []string, rctx RunContext, usage func()) {
 	f := flag.NewFlagSet(name, flag.ExitOnError)
 	var (
-		workers    = f.Int("w", 1, "number of workers (concurrency)")
-		iterations = f.Int("i", 1, "number of iterations of connects")
+		workers     = f.Int("w", 1, "number of workers (concurrency); maximum concurrent connects with -rate or -stages")
+		iterations  = f.Int("i", 1, "number of iterations of connects")
+		rate        = f.Float64("rate", 0, "connects per second (open model; use with -duration)")
+		duration    = f.Duration("duration", time.Minute, "duration of connects at -rate")
+		stagesStr   = f.String("stages", "", "load stages of duration:rate or duration:from-to (e.g. 30s:0-100,5m:100,30s:100-0)")
+		maxLateness = f.Duration("max-lateness", time.Second, "drop connects that can't start within this time of being scheduled")
+		reportOpts  = &reportOptions{}
+		limits      = newThresholds()
 	)
+	reportOpts.addFlags(f)
+	limits.addFlags(f)
 	setSubCommandFlagSetUsage(f, usage)
 	f.Parse(args)
 
+	reportOpts.validateFlags(f)
+
+	var stages []loadStage
+	if *stagesStr != "" {
+		var err error
+		stages, err = parseStages(*stagesStr)
+		if err != nil {
+			fmt.Fprintln(f.Output(), err)
+			f.Usage()
+			os.Exit(2)
+		}
+	} else if *rate > 0 {
+		stages = []loadStage{{Duration: *duration, From: *rate, To: *rate}}
+	}
+
 	err := checkDeviceUUIDs(rctx, false, name)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	workerData := []*ConnectWorkerData{}
-
-	for _, u := range rctx.UUIDs {
-		dev, err := device.Load(u, rctx.DB)
-		if err != nil {
-			log.Println(err)
-			continue
-		}
+	workerData := loadConnectWorkerData(rctx, rctx.UUIDs)
 
-		client, err := dev.MDMClient()
-		if err != nil {
-			log.Println(err)
-			continue
-		}
-
-		workerData = append(workerData, &ConnectWorkerData{
-			Device:    dev,
-			MDMClient: client,
-		})
+	report := &benchReport{
+		Operation: "connect",
+		Devices:   len(workerData),
+		Workers:   *workers,
 	}
-
-	startConnectWorkers(rctx.Context, workerData, *workers, *iterations)
+	runDeviceOpBenchmark(rctx, name, "MDM connect", report, func(ctx context.Context, reqLog requestLog, progress io.Writer) *statsSummary {
+		if len(stages) > 0 {
+			var summary *statsSummary
+			summary, report.Load = startConnectLoad(ctx, workerData, stages, *workers, *maxLateness, reqLog, progress)
+			return summary
+		}
+		report.Iterations = *iterations
+		return startConnectWorkers(ctx, workerData, *workers, *iterations, reqLog, progress)
+	}, reportOpts, limits)
 }
 
 func devicesProfilesList(name string, args []string, rctx RunContext, usage func()) {
@@ -344,7 +473,7 @@ func devicesProfilesList(name string, args []string, rctx RunContext, usage func
 
 	for _, u := range rctx.UUIDs {
 		fmt.Printf("profiles for UUID: %s\n", u)
-		dev, err := device.Load(u, rctx.DB)
+		dev, err := rctx.LoadDevice(u)
 		if err != nil {
 			log.Println(err)
 			continue
@@ -386,7 +515,7 @@ func devicesMdmSignature(name string, args []string, rctx RunContext, usage func
 	}
 
 	for _, u := range rctx.UUIDs {
-		dev, err := device.Load(u, rctx.DB)
+		dev, err := rctx.LoadDevice(u)
 		if err != nil {
 			log.Println(err)
 			continue
@@ -431,15 +560,30 @@ func devicesProfilesRemove(name string, args []string, rctx RunContext, usage fu
 		log.Fatal(err)
 	}
 
+	// report the MDM server's response to any check-out
+	var checkOutStatus int
+	ctx := device.WithTrace(rctx.Context, &device.Trace{
+		HTTPResponse: func(_ string, statusCode int) {
+			checkOutStatus = statusCode
+		},
+		CheckIn: func(messageType string, d time.Duration, err error) {
+			if err != nil {
+				fmt.Printf("%s failed after %s: %v\n", messageType, d, err)
+			} else {
+				fmt.Printf("%s: HTTP %d (%s)\n", messageType, checkOutStatus, d)
+			}
+		},
+	})
+
 	for _, u := range rctx.UUIDs {
 		fmt.Println(u)
-		dev, err := device.Load(u, rctx.DB)
+		dev, err := rctx.LoadDevice(u)
 		if err != nil {
 			log.Println(err)
 			continue
 		}
 
-		err = dev.RemoveProfile(*id)
+		err = dev.RemoveProfile(ctx, *id)
 		if err != nil {
 			log.Println(err)
 			continue
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jessepeterson/mdmb/internal/device"
+)
+
+// metricFamily is a Prometheus counter or histogram with labels.
+type metricFamily struct {
+	name   string
+	help   string
+	typ    string // "counter" or "histogram"
+	labels []string
+	series map[string]*metricSeries // by joined label values
+}
+
+type metricSeries struct {
+	labelValues []string
+
+	value float64 // counter
+
+	buckets []uint64 // histogram, per latencyBuckets (non-cumulative)
+	count   uint64
+	sum     float64
+}
+
+// metrics collects Prometheus metrics of MDM operations and exposes
+// them in the Prometheus text format. It is safe for concurrent use.
+type metrics struct {
+	mu       sync.Mutex
+	families []*metricFamily
+
+	operations        *metricFamily
+	operationDuration *metricFamily
+	errors            *metricFamily
+	httpResponses     *metricFamily
+	checkIns          *metricFamily
+	checkInDuration   *metricFamily
+	commands          *metricFamily
+	scepOperations    *metricFamily
+	scepDuration      *metricFamily
+}
+
+func newMetrics() *metrics {
+	m := &metrics{}
+	m.operations = m.family("mdmb_operations_total", "counter", "Device operations (e.g. MDM connects) by result.", "phase", "operation", "result")
+	m.operationDuration = m.family("mdmb_operation_duration_seconds", "histogram", "Duration of device operations.", "phase", "operation")
+	m.errors = m.family("mdmb_errors_total", "counter", "Device operation errors by cause.", "phase", "operation", "cause")
+	m.httpResponses = m.family("mdmb_http_responses_total", "counter", "MDM HTTP responses by request and status code.", "phase", "request", "code")
+	m.checkIns = m.family("mdmb_checkins_total", "counter", "MDM check-in requests by message type and result.", "phase", "message_type", "result")
+	m.checkInDuration = m.family("mdmb_checkin_duration_seconds", "histogram", "Duration of MDM check-in requests.", "phase", "message_type")
+	m.commands = m.family("mdmb_commands_total", "counter", "MDM commands handled by request type and status.", "phase", "request_type", "status")
+	m.scepOperations = m.family("mdmb_scep_operations_total", "counter", "SCEP operations by result.", "phase", "operation", "result")
+	m.scepDuration = m.family("mdmb_scep_operation_duration_seconds", "histogram", "Duration of SCEP operations.", "phase", "operation")
+	return m
+}
+
+func (m *metrics) family(name, typ, help string, labels ...string) *metricFamily {
+	f := &metricFamily{
+		name:   name,
+		help:   help,
+		typ:    typ,
+		labels: labels,
+		series: make(map[string]*metricSeries),
+	}
+	m.families = append(m.families, f)
+	return f
+}
+
+// seriesFor returns the series of f with labelValues, creating it if
+// needed. m.mu must be held.
+func (f *metricFamily) seriesFor(labelValues []string) *metricSeries {
+	key := strings.Join(labelValues, "\x00")
+	s, ok := f.series[key]
+	if !ok {
+		s = &metricSeries{labelValues: labelValues}
+		if f.typ == "histogram" {
+			s.buckets = make([]uint64, len(latencyBuckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (m *metrics) inc(f *metricFamily, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f.seriesFor(labelValues).value++
+}
+
+func (m *metrics) observe(f *metricFamily, d time.Duration, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := f.seriesFor(labelValues)
+	i := sort.Search(len(latencyBuckets), func(i int) bool { return d <= latencyBuckets[i] })
+	if i < len(s.buckets) {
+		s.buckets[i]++
+	}
+	s.count++
+	s.sum += d.Seconds()
+}
+
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// labelValueEscaper escapes label values for the Prometheus text
+// exposition format.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabel(name, value string) string {
+	return name + `="` + labelValueEscaper.Replace(value) + `"`
+}
+
+func formatLabels(names, values []string, extra ...string) string {
+	var pairs []string
+	for i, name := range names {
+		pairs = append(pairs, formatLabel(name, values[i]))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, formatLabel(extra[i], extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// write writes all metrics in the Prometheus text exposition format.
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, f := range m.families {
+		fmt.Fprintf(w, "# HELP %s %s\n", f.name, f.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+		keys := make([]string, 0, len(f.series))
+		for k := range f.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s := f.series[k]
+			if f.typ != "histogram" {
+				fmt.Fprintf(w, "%s%s %s\n", f.name, formatLabels(f.labels, s.labelValues), formatFloat(s.value))
+				continue
+			}
+			var cumulative uint64
+			for i, bound := range latencyBuckets {
+				cumulative += s.buckets[i]
+				fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, s.labelValues, "le", formatFloat(bound.Seconds())), cumulative)
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, s.labelValues, "le", "+Inf"), s.count)
+			fmt.Fprintf(w, "%s_sum%s %s\n", f.name, formatLabels(f.labels, s.labelValues), formatFloat(s.sum))
+			fmt.Fprintf(w, "%s_count%s %d\n", f.name, formatLabels(f.labels, s.labelValues), s.count)
+		}
+	}
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.write(w)
+}
+
+// serveMetrics starts serving metrics on /metrics at addr.
+func serveMetrics(addr string, m *metrics) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	go func() {
+		err := http.ListenAndServe(addr, mux)
+		log.Println(fmt.Errorf("metrics listener: %w", err))
+	}()
+}
+
+// phaseMetrics records metrics labeled with a phase (e.g. a subcommand
+// or scenario phase name).
+type phaseMetrics struct {
+	m     *metrics
+	phase string
+}
+
+type phaseMetricsKey struct{}
+
+// withPhaseMetrics returns a new context based on ctx that records
+// the device operations performed with it into m labeled with phase.
+// ctx is returned unchanged if m is nil.
+func withPhaseMetrics(ctx context.Context, m *metrics, phase string) context.Context {
+	if m == nil {
+		return ctx
+	}
+	pm := &phaseMetrics{m: m, phase: phase}
+	ctx = device.WithTrace(ctx, &device.Trace{
+		HTTPResponse: func(request string, statusCode int) {
+			m.inc(m.httpResponses, phase, request, strconv.Itoa(statusCode))
+		},
+		CommandHandled: func(requestType, status string) {
+			m.inc(m.commands, phase, requestType, status)
+		},
+		CheckIn: func(messageType string, d time.Duration, err error) {
+			m.inc(m.checkIns, phase, messageType, resultLabel(err))
+			m.observe(m.checkInDuration, d, phase, messageType)
+		},
+		SCEPOperation: func(operation string, d time.Duration, err error) {
+			m.inc(m.scepOperations, phase, operation, resultLabel(err))
+			m.observe(m.scepDuration, d, phase, operation)
+		},
+	})
+	return context.WithValue(ctx, phaseMetricsKey{}, pm)
+}
+
+func contextPhaseMetrics(ctx context.Context) *phaseMetrics {
+	pm, _ := ctx.Value(phaseMetricsKey{}).(*phaseMetrics)
+	return pm
+}
+
+// operation records the result of a device operation (e.g. "device
+// connect") that took d.
+func (pm *phaseMetrics) operation(name string, d time.Duration, err error) {
+	if pm == nil {
+		return
+	}
+	pm.m.inc(pm.m.operations, pm.phase, name, resultLabel(err))
+	if err != nil {
+		pm.m.inc(pm.m.errors, pm.phase, name, errorCause(err))
+		return
+	}
+	pm.m.observe(pm.m.operationDuration, d, pm.phase, name)
+}
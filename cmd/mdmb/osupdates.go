@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/jessepeterson/mdmb/internal/device"
+)
+
+// devicesOSUpdates adds a (simulated) OS update to the OS update catalog
+// of devices, available in AvailableOSUpdates and ScheduleOSUpdate.
+func devicesOSUpdates(name string, args []string, rctx RunContext, usage func()) {
+	f := flag.NewFlagSet(name, flag.ExitOnError)
+	var (
+		version      = f.String("version", "", "OS version of the update (e.g. 15.5)")
+		build        = f.String("build", "", "build version of the update (e.g. 24F74)")
+		productKey   = f.String("product-key", "", "product key of the update (default MSU_UPDATE_<build>_patch_<version>)")
+		updateName   = f.String("name", "", "human readable name of the update (default <OS family> <version>)")
+		downloadSize = f.Int64("download-size", 3000000000, "download size of the update in bytes")
+		critical     = f.Bool("critical", false, "update is critical")
+		major        = f.Bool("major", false, "update is a major OS update")
+		clearUpdates = f.Bool("clear", false, "remove the OS updates of the devices before adding the update (if any)")
+	)
+	setSubCommandFlagSetUsage(f, usage)
+	f.Parse(args)
+
+	if (*version == "" || *build == "") && !(*clearUpdates && *version == "" && *build == "") {
+		fmt.Fprintln(f.Output(), "must specify update version and build (or only -clear)")
+		f.Usage()
+		os.Exit(2)
+	}
+
+	err := checkDeviceUUIDs(rctx, false, name)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, u := range rctx.UUIDs {
+		dev, err := rctx.LoadDevice(u)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		var updates []device.OSUpdate
+		if !*clearUpdates {
+			if updates, err = dev.OSUpdates(); err != nil {
+				log.Println(err)
+				continue
+			}
+		}
+		if *version != "" {
+			update := device.OSUpdate{
+				ProductKey:        *productKey,
+				HumanReadableName: *updateName,
+				Version:           *version,
+				Build:             *build,
+				DownloadSize:      *downloadSize,
+				InstallSize:       *downloadSize * 2,
+				IsCritical:        *critical,
+				IsMajorOSUpdate:   *major,
+			}
+			if update.ProductKey == "" {
+				update.ProductKey = fmt.Sprintf("MSU_UPDATE_%s_patch_%s", *build, *version)
+			}
+			if update.HumanReadableName == "" {
+				_, osFamily := dev.ModelFamily()
+				update.HumanReadableName = osFamily + " " + *version
+			}
+			updates = addOSUpdate(updates, update)
+		}
+		if err = dev.SetOSUpdates(updates); err != nil {
+			log.Println(err)
+			continue
+		}
+		fmt.Println(u)
+	}
+}
+
+// addOSUpdate adds update to updates replacing an update with the same
+// product key.
+func addOSUpdate(updates []device.OSUpdate, update device.OSUpdate) []device.OSUpdate {
+	for i, u := range updates {
+		if u.ProductKey == update.ProductKey {
+			updates[i] = update
+			return updates
+		}
+	}
+	return append(updates, update)
+}
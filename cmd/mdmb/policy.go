@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/jessepeterson/mdmb/internal/device"
+)
+
+// loadResponsePolicies reads a JSON file of MDM command response
+// policies.
+func loadResponsePolicies(path string) ([]device.ResponsePolicy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var policies []device.ResponsePolicy
+	if err = json.Unmarshal(b, &policies); err != nil {
+		return nil, fmt.Errorf("parsing response policies %s: %w", path, err)
+	}
+	for i := range policies {
+		if err = policies[i].Validate(); err != nil {
+			return nil, fmt.Errorf("response policy %d: %w", i+1, err)
+		}
+	}
+	return policies, nil
+}
+
+// devicesResponsePolicy sets the MDM command response policies of
+// devices.
+func devicesResponsePolicy(name string, args []string, rctx RunContext, usage func()) {
+	f := flag.NewFlagSet(name, flag.ExitOnError)
+	var (
+		file        = f.String("f", "", "JSON file of response policies to add")
+		requestType = f.String("request-type", "", "command RequestType of the policy to add (default all commands)")
+		response    = f.String("response", "", "response of the policy to add: Acknowledged, NotNow, Error, or Drop")
+		probability = f.Float64("probability", 0, "probability (0 to 1) the policy to add applies (0 for always)")
+		delay       = f.Duration("delay", 0, "artificial handling delay of the policy to add")
+		errCode     = f.Int("error-code", 0, "ErrorCode of an Error response")
+		errDomain   = f.String("error-domain", "MCMDMErrorDomain", "ErrorDomain of an Error response")
+		errDesc     = f.String("error-description", "", "LocalizedDescription of an Error response")
+		clearFirst  = f.Bool("clear", false, "remove the response policies of the devices first")
+	)
+	setSubCommandFlagSetUsage(f, usage)
+	f.Parse(args)
+
+	var policies []device.ResponsePolicy
+	if *file != "" {
+		var err error
+		if policies, err = loadResponsePolicies(*file); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *response != "" {
+		policy := device.ResponsePolicy{
+			RequestType: *requestType,
+			Response:    *response,
+			Probability: *probability,
+			Delay:       device.Duration(*delay),
+		}
+		if *errCode != 0 || *errDesc != "" {
+			policy.ErrorChain = []device.ErrorChain{{
+				ErrorCode:            *errCode,
+				ErrorDomain:          *errDomain,
+				LocalizedDescription: *errDesc,
+			}}
+		}
+		if err := policy.Validate(); err != nil {
+			fmt.Fprintln(f.Output(), err)
+			f.Usage()
+			os.Exit(2)
+		}
+		policies = append(policies, policy)
+	}
+	if len(policies) < 1 && !*clearFirst {
+		fmt.Fprintln(f.Output(), "must specify response policies (-f or -response) or -clear")
+		f.Usage()
+		os.Exit(2)
+	}
+
+	err := checkDeviceUUIDs(rctx, false, name)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, u := range rctx.UUIDs {
+		dev, err := rctx.LoadDevice(u)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		var devPolicies []device.ResponsePolicy
+		if !*clearFirst {
+			// add to the existing policies
+			if devPolicies, err = dev.ResponsePolicies(); err != nil {
+				log.Println(err)
+				continue
+			}
+		}
+		devPolicies = append(devPolicies, policies...)
+		if err = dev.SetResponsePolicies(devPolicies); err != nil {
+			log.Println(err)
+			continue
+		}
+		fmt.Println(u)
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jessepeterson/mdmb/internal/device"
+)
+
+// requestRecord is the per-request log entry of a device operation.
+type requestRecord struct {
+	UDID       string    `json:"udid"`
+	Iteration  int       `json:"iteration"`
+	Start      time.Time `json:"start"`
+	Duration   float64   `json:"duration_ms"`
+	HTTPStatus int       `json:"http_status,omitempty"` // of the last HTTP response
+	Commands   []string  `json:"commands,omitempty"`    // request types handled
+	Error      string    `json:"error,omitempty"`
+
+	// HTTP timing breakdown summed over all HTTP requests
+	Requests int     `json:"http_requests,omitempty"`
+	DNS      float64 `json:"dns_ms,omitempty"`
+	Connect  float64 `json:"connect_ms,omitempty"`
+	TLS      float64 `json:"tls_ms,omitempty"`
+	TTFB     float64 `json:"ttfb_ms,omitempty"`
+	Transfer float64 `json:"transfer_ms,omitempty"`
+}
+
+// newRequestRecord starts a record and returns a context that traces
+// the operation into it.
+func newRequestRecord(ctx context.Context, udid string, iteration int) (context.Context, *requestRecord) {
+	rec := &requestRecord{UDID: udid, Iteration: iteration, Start: time.Now()}
+	ctx = device.WithTrace(ctx, &device.Trace{
+		HTTPResponse: func(_ string, statusCode int) {
+			rec.HTTPStatus = statusCode
+		},
+		CommandHandled: func(requestType, _ string) {
+			rec.Commands = append(rec.Commands, requestType)
+		},
+		HTTPTiming: func(_ string, t device.HTTPTiming) {
+			rec.Requests++
+			if !t.Reused {
+				rec.DNS += durationMs(t.DNS)
+				rec.Connect += durationMs(t.Connect)
+				rec.TLS += durationMs(t.TLS)
+			}
+			rec.TTFB += durationMs(t.TTFB)
+			rec.Transfer += durationMs(t.Transfer)
+		},
+	})
+	return ctx, rec
+}
+
+// finish completes the record with the elapsed time and error.
+func (rec *requestRecord) finish(err error) time.Duration {
+	d := time.Since(rec.Start)
+	rec.Duration = durationMs(d)
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	return d
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// requestLog writes request records. It is safe for concurrent use.
+type requestLog interface {
+	write(*requestRecord) error
+	close() error
+}
+
+var requestLogCSVHeader = []string{
+	"udid", "iteration", "start", "duration_ms", "http_status", "commands", "error",
+	"http_requests", "dns_ms", "connect_ms", "tls_ms", "ttfb_ms", "transfer_ms",
+}
+
+func formatMs(ms float64) string {
+	return strconv.FormatFloat(ms, 'f', 3, 64)
+}
+
+type csvRequestLog struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *csv.Writer
+}
+
+func (l *csvRequestLog) write(rec *requestRecord) error {
+	var status string
+	if rec.HTTPStatus != 0 {
+		status = strconv.Itoa(rec.HTTPStatus)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write([]string{
+		rec.UDID,
+		strconv.Itoa(rec.Iteration),
+		rec.Start.Format(time.RFC3339Nano),
+		formatMs(rec.Duration),
+		status,
+		strings.Join(rec.Commands, ";"),
+		rec.Error,
+		strconv.Itoa(rec.Requests),
+		formatMs(rec.DNS),
+		formatMs(rec.Connect),
+		formatMs(rec.TLS),
+		formatMs(rec.TTFB),
+		formatMs(rec.Transfer),
+	})
+}
+
+func (l *csvRequestLog) close() error {
+	l.w.Flush()
+	if err := l.w.Error(); err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
+
+type jsonlRequestLog struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+func (l *jsonlRequestLog) write(rec *requestRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.enc.Encode(rec)
+}
+
+func (l *jsonlRequestLog) close() error {
+	return l.f.Close()
+}
+
+// openRequestLog creates a request log file at path. format is either
+// "csv" or "jsonl" or empty to use the file extension.
+func openRequestLog(path, format string) (requestLog, error) {
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(path), ".")
+	}
+	switch format {
+	case "csv", "jsonl":
+	default:
+		return nil, fmt.Errorf("unknown request log format: %q", format)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if format == "jsonl" {
+		return &jsonlRequestLog{f: f, enc: json.NewEncoder(f)}, nil
+	}
+	l := &csvRequestLog{f: f, w: csv.NewWriter(f)}
+	if err = l.w.Write(requestLogCSVHeader); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// MarshalJSON encodes durations as floating point milliseconds.
+func (ss *statsSummary) MarshalJSON() ([]byte, error) {
+	type bucket struct {
+		LessOrEqualMs *float64 `json:"le_ms"` // null for +Inf
+		Count         int      `json:"count"`
+	}
+	latency := map[string]float64{
+		"min":    durationMs(ss.Min),
+		"max":    durationMs(ss.Max),
+		"mean":   durationMs(ss.Mean),
+		"stddev": durationMs(ss.Stddev),
+	}
+	for _, p := range ss.Percentiles {
+		latency["p"+formatPercentile(p.Percentile)] = durationMs(p.Value)
+	}
+	var histogram []bucket
+	for _, b := range ss.Histogram {
+		jb := bucket{Count: b.Count}
+		if b.UpperBound > 0 {
+			le := durationMs(b.UpperBound)
+			jb.LessOrEqualMs = &le
+		}
+		histogram = append(histogram, jb)
+	}
+	return json.Marshal(&struct {
+		Total          int                `json:"total"`
+		Errors         int                `json:"errors"`
+		ErrorRate      float64            `json:"error_rate_percent"`
+		ErrorCauses    map[string]int     `json:"error_causes"`
+		ElapsedSeconds float64            `json:"elapsed_seconds"`
+		Throughput     float64            `json:"throughput_per_second"`
+		LatencyMs      map[string]float64 `json:"latency_ms"`
+		Histogram      []bucket           `json:"histogram"`
+	}{
+		Total:          ss.Total,
+		Errors:         ss.Errors,
+		ErrorRate:      ss.ErrorRate(),
+		ErrorCauses:    ss.ErrorCauses,
+		ElapsedSeconds: ss.Elapsed.Seconds(),
+		Throughput:     ss.Throughput,
+		LatencyMs:      latency,
+		Histogram:      histogram,
+	})
+}
+
+// benchReport is the machine-readable report of a benchmark run.
+type benchReport struct {
+	Name       string         `json:"name,omitempty"`
+	Operation  string         `json:"operation"`
+	Started    time.Time      `json:"started"`
+	Devices    int            `json:"devices"`
+	Workers    int            `json:"workers,omitempty"`
+	Iterations int            `json:"iterations,omitempty"`
+	Load       *loadReport    `json:"load,omitempty"`
+	Summary    *statsSummary  `json:"summary"`
+	Stages     []stageSummary `json:"stages,omitempty"`
+
+	// HTTP timing breakdown of all HTTP requests
+	HTTPTimings []stageSummary `json:"http_timings,omitempty"`
+
+	Thresholds []thresholdResult `json:"thresholds,omitempty"`
+}
+
+// reportOptions configure the output of benchmark subcommands.
+type reportOptions struct {
+	format  string // "text" or "json"
+	outPath string
+	logPath string
+	logFmt  string
+}
+
+func (o *reportOptions) addFlags(f *flag.FlagSet) {
+	f.StringVar(&o.format, "format", "text", "summary output format: text or json")
+	f.StringVar(&o.outPath, "out", "", "path to write summary to instead of stdout")
+	f.StringVar(&o.logPath, "log", "", "path to per-request log file")
+	f.StringVar(&o.logFmt, "log-format", "", "per-request log format: csv or jsonl (default from log file extension)")
+}
+
+func (o *reportOptions) validate() error {
+	if o.format != "text" && o.format != "json" {
+		return errors.New("output format must be text or json")
+	}
+	return nil
+}
+
+// validateFlags validates the options parsed from f, exiting with the
+// usage of f if they're invalid.
+func (o *reportOptions) validateFlags(f *flag.FlagSet) {
+	if err := o.validate(); err != nil {
+		fmt.Fprintln(f.Output(), err)
+		f.Usage()
+		os.Exit(2)
+	}
+}
+
+// progress returns the writer for progress output. Progress goes to
+// stderr when stdout is used for machine-readable output.
+func (o *reportOptions) progress() io.Writer {
+	if o.format == "json" {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// openLog opens the per-request log, if configured.
+func (o *reportOptions) openLog() (requestLog, error) {
+	if o.logPath == "" {
+		return nil, nil
+	}
+	return openRequestLog(o.logPath, o.logFmt)
+}
+
+// write writes v as JSON or, for text format, using text.
+func (o *reportOptions) write(v interface{}, text func(io.Writer)) error {
+	var out io.Writer = os.Stdout
+	if o.outPath != "" {
+		f, err := os.Create(o.outPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	if o.format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	text(out)
+	return nil
+}
+
+// output writes the report summary in the configured format.
+func (o *reportOptions) output(r *benchReport, name string) error {
+	return o.write(r, func(out io.Writer) { r.print(out, name) })
+}
+
+// print writes a human readable report. name describes the operation.
+func (r *benchReport) print(out io.Writer, name string) {
+	if r.Load != nil {
+		r.Load.print(out)
+		fmt.Fprintln(out)
+	}
+	r.Summary.print(out, name)
+	if len(r.Stages) > 0 {
+		fmt.Fprintf(out, "\n%s stages:\n", name)
+		printStageSummaries(out, r.Stages)
+	}
+	if len(r.HTTPTimings) > 0 {
+		fmt.Fprintln(out, "\nHTTP request timings:")
+		printStageSummaries(out, r.HTTPTimings)
+	}
+	if len(r.Thresholds) > 0 {
+		fmt.Fprintln(out, "\nThresholds:")
+		printThresholdResults(out, r.Thresholds)
+	}
+}
+
+// reportTimings collects the stage timings (SCEP operations and
+// check-in messages) and HTTP timing breakdown of device operations
+// for benchmark reports.
+type reportTimings struct {
+	stages *stageStats
+	http   *stageStats
+}
+
+func newReportTimings() *reportTimings {
+	return &reportTimings{stages: newStageStats(), http: newStageStats()}
+}
+
+// context returns a new context based on ctx that collects timings.
+func (rt *reportTimings) context(ctx context.Context) context.Context {
+	return device.WithTrace(ctx, &device.Trace{
+		CheckIn:       rt.stages.add,
+		SCEPOperation: rt.stages.add,
+		HTTPTiming: func(_ string, t device.HTTPTiming) {
+			// connection phases only happen for new connections
+			if !t.Reused {
+				if t.DNS > 0 {
+					rt.http.add("DNS", t.DNS, nil)
+				}
+				if t.Connect > 0 {
+					rt.http.add("TCP connect", t.Connect, nil)
+				}
+				if t.TLS > 0 {
+					rt.http.add("TLS handshake", t.TLS, nil)
+				}
+			}
+			rt.http.add("TTFB", t.TTFB, nil)
+			rt.http.add("Transfer", t.Transfer, nil)
+			rt.http.add("Total", t.Total, nil)
+		},
+	})
+}
+
+// finish summarizes the collected timings into r.
+func (rt *reportTimings) finish(r *benchReport) {
+	r.Stages = rt.stages.summaries()
+	r.HTTPTimings = rt.http.summaries()
+}
+
+// benchmarkRun performs the operation of a benchmark and returns its
+// summary.
+type benchmarkRun func(ctx context.Context, reqLog requestLog, progress io.Writer) *statsSummary
+
+// eachDevice performs op once on each device using a pool of workers.
+func eachDevice(uuids []string, workers int, opName string, op deviceOp) benchmarkRun {
+	return func(ctx context.Context, reqLog requestLog, progress io.Writer) *statsSummary {
+		return startDeviceWorkers(ctx, uuids, workers, opName, op, reqLog, progress)
+	}
+}
+
+// runDeviceOpBenchmark runs the benchmark of report for the subcommand
+// name and outputs report with its summary, timings, and threshold
+// results. opName describes the operation. It exits with a non-zero
+// status if any threshold fails.
+func runDeviceOpBenchmark(rctx RunContext, name, opName string, report *benchReport, run benchmarkRun, reportOpts *reportOptions, limits *thresholds) {
+	reqLog, err := reportOpts.openLog()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report.Started = time.Now()
+	timings := newReportTimings()
+	ctx := timings.context(withPhaseMetrics(rctx.Context, rctx.Metrics, name))
+	report.Summary = run(ctx, reqLog, reportOpts.progress())
+	timings.finish(report)
+	report.Thresholds = limits.check(report.Summary)
+
+	if reqLog != nil {
+		if err = reqLog.close(); err != nil {
+			log.Println(err)
+		}
+	}
+	if err = reportOpts.output(report, opName); err != nil {
+		log.Fatal(err)
+	}
+	exitOnThresholdFailure(report)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	mathrand "math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jessepeterson/mdmb/internal/apns"
+)
+
+// jitter returns d randomly adjusted by up to +/- the fraction j of d.
+func jitter(d time.Duration, j float64) time.Duration {
+	if j <= 0 {
+		return d
+	}
+	return d + time.Duration((mathrand.Float64()*2-1)*j*float64(d))
+}
+
+// runDevice periodically connects a device until ctx is done.
+func runDevice(ctx context.Context, c *connector, udid string, interval time.Duration, j float64) {
+	// spread the initial connects out over the interval like a fleet
+	// of devices that didn't all boot at the same time
+	t := time.NewTimer(time.Duration(mathrand.Int63n(int64(interval))))
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			c.connect(udid)
+			t.Reset(jitter(interval, j))
+		}
+	}
+}
+
+func devicesRun(name string, args []string, rctx RunContext, usage func()) {
+	f := flag.NewFlagSet(name, flag.ExitOnError)
+	var (
+		interval   = f.Duration("interval", 15*time.Minute, "interval between periodic connects (0 to disable)")
+		jitterFrac = f.Float64("jitter", 0.1, "randomly vary the interval by up to this fraction")
+		workers    = f.Int("w", 0, "maximum concurrent connects (0 for unlimited)")
+		apnsListen = f.String("apns-listen", "", "HTTP/2 (TLS) listen address of APNs server to trigger connects")
+		apnsCert   = f.String("apns-cert", "", "APNs server TLS certificate file (self-signed if not supplied)")
+		apnsKey    = f.String("apns-key", "", "APNs server TLS private key file")
+	)
+	setSubCommandFlagSetUsage(f, usage)
+	f.Parse(args)
+
+	err := checkDeviceUUIDs(rctx, false, name)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *interval <= 0 && *apnsListen == "" {
+		fmt.Fprintln(f.Output(), "must specify an interval or APNs listen address")
+		f.Usage()
+		os.Exit(2)
+	}
+
+	var (
+		wg          sync.WaitGroup
+		st          = newStats()
+		c           = newConnector(withPhaseMetrics(rctx.Context, rctx.Metrics, name), rctx, *workers)
+		apnsErrChan = make(chan error, 1)
+	)
+	c.result = func(_ string, d time.Duration, err error) {
+		st.add(d, err)
+	}
+
+	if *apnsListen != "" {
+		pc := newPushConnector(c, rctx.UUIDs)
+		srv, err := newAPNsHTTPServer(*apnsListen, *apnsCert, *apnsKey, apns.New(pc.notify))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("starting APNs server on %s\n", *apnsListen)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			apnsErrChan <- serveUntilDone(rctx.Context, srv)
+		}()
+	}
+
+	if *interval > 0 {
+		fmt.Printf("running %d devices connecting every %s\n", len(rctx.UUIDs), *interval)
+		for _, u := range rctx.UUIDs {
+			wg.Add(1)
+			go func(udid string) {
+				defer wg.Done()
+				runDevice(rctx.Context, c, udid, *interval, *jitterFrac)
+			}(u)
+		}
+	}
+
+	select {
+	case <-rctx.Context.Done():
+	case err = <-apnsErrChan:
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	fmt.Println("shutting down")
+	wg.Wait()
+	c.wait()
+	st.stop()
+
+	fmt.Println()
+	st.summary().print(os.Stdout, "MDM connect")
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// durationValue is a time.Duration that is a duration string in JSON
+// and can be used as a flag.Value.
+type durationValue time.Duration
+
+func (d *durationValue) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	return d.Set(s)
+}
+
+func (d *durationValue) Set(s string) error {
+	pd, err := time.ParseDuration(s)
+	*d = durationValue(pd)
+	return err
+}
+
+func (d *durationValue) String() string {
+	return time.Duration(*d).String()
+}
+
+// scenarioPhase is a single step of a scenario.
+type scenarioPhase struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+
+	// limit the phase to the first number of scenario devices (0 for all)
+	Devices int `json:"devices"`
+	Workers int `json:"workers"`
+
+	// create
+	Count int `json:"count"`
+	deviceAttrs
+
+	// install-profile
+	File string `json:"file"`
+
+	// token-update
+	Addl string `json:"addl"`
+
+	// connect
+	Iterations  int           `json:"iterations"`
+	Rate        float64       `json:"rate"`
+	Duration    durationValue `json:"duration"` // also pause
+	Stages      string        `json:"stages"`
+	MaxLateness durationValue `json:"max_lateness"`
+
+	// remove-profile
+	Identifier string `json:"identifier"`
+
+	// ddm-status
+	StatusItems map[string]interface{} `json:"status_items"`
+	Full        bool                   `json:"full"`
+
+	// pass/fail assertions on the phase summary
+	Thresholds *thresholds `json:"thresholds"`
+
+	profile []byte
+	stages  []loadStage
+}
+
+// scenario is a reproducible, multi-phase benchmark.
+type scenario struct {
+	Name   string           `json:"name"`
+	Phases []*scenarioPhase `json:"phases"`
+}
+
+// loadScenario reads and validates a scenario file. Profile paths are
+// relative to the scenario file.
+func loadScenario(path string) (*scenario, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sc := &scenario{}
+	if err = json.Unmarshal(b, sc); err != nil {
+		return nil, fmt.Errorf("parsing scenario: %w", err)
+	}
+	if len(sc.Phases) < 1 {
+		return nil, errors.New("scenario has no phases")
+	}
+	for i, p := range sc.Phases {
+		if p.Name == "" {
+			p.Name = fmt.Sprintf("%d-%s", i+1, p.Type)
+		}
+		if p.Workers < 1 {
+			p.Workers = 1
+		}
+		switch p.Type {
+		case "create":
+			if p.Count < 1 {
+				return nil, fmt.Errorf("phase %s: count must be greater than zero", p.Name)
+			}
+			if p.Apps != "" && !filepath.IsAbs(p.Apps) {
+				p.Apps = filepath.Join(filepath.Dir(path), p.Apps)
+			}
+			if err = p.loadApps(); err != nil {
+				return nil, fmt.Errorf("phase %s: %w", p.Name, err)
+			}
+		case "install-profile":
+			if p.File == "" {
+				return nil, fmt.Errorf("phase %s: must specify profile file", p.Name)
+			}
+			if !filepath.IsAbs(p.File) {
+				p.File = filepath.Join(filepath.Dir(path), p.File)
+			}
+			if p.profile, err = os.ReadFile(p.File); err != nil {
+				return nil, fmt.Errorf("phase %s: %w", p.Name, err)
+			}
+		case "token-update":
+		case "ddm-sync":
+		case "ddm-status":
+		case "connect":
+			if p.Stages != "" {
+				if p.stages, err = parseStages(p.Stages); err != nil {
+					return nil, fmt.Errorf("phase %s: %w", p.Name, err)
+				}
+			} else if p.Rate > 0 {
+				if p.Duration <= 0 {
+					return nil, fmt.Errorf("phase %s: must specify duration with rate", p.Name)
+				}
+				p.stages = []loadStage{{Duration: time.Duration(p.Duration), From: p.Rate, To: p.Rate}}
+			} else if p.Iterations < 1 {
+				p.Iterations = 1
+			}
+			if p.MaxLateness <= 0 {
+				p.MaxLateness = durationValue(time.Second)
+			}
+		case "remove-profile":
+			if p.Identifier == "" {
+				return nil, fmt.Errorf("phase %s: must specify profile identifier", p.Name)
+			}
+		case "pause":
+			if p.Duration <= 0 {
+				return nil, fmt.Errorf("phase %s: must specify duration", p.Name)
+			}
+		default:
+			return nil, fmt.Errorf("phase %s: unknown type: %q", p.Name, p.Type)
+		}
+	}
+	return sc, nil
+}
+
+// scenarioOpNames describe the operations of each phase type.
+var scenarioOpNames = map[string]string{
+	"create":          "device create",
+	"install-profile": "profile install",
+	"token-update":    "token update",
+	"ddm-sync":        "DDM sync",
+	"ddm-status":      "DDM status report",
+	"connect":         "MDM connect",
+	"remove-profile":  "profile remove",
+}
+
+// scenarioReport is the machine-readable report of a scenario run.
+type scenarioReport struct {
+	Name    string         `json:"name"`
+	Started time.Time      `json:"started"`
+	Phases  []*benchReport `json:"phases"`
+}
+
+// scenarioRunner runs scenario phases against a growing set of devices.
+type scenarioRunner struct {
+	rctx     RunContext
+	uuids    []string
+	reqLog   requestLog
+	progress io.Writer
+}
+
+func (r *scenarioRunner) phaseDevices(p *scenarioPhase) []string {
+	if p.Devices > 0 && p.Devices < len(r.uuids) {
+		return r.uuids[:p.Devices]
+	}
+	return r.uuids
+}
+
+// run runs a single phase and returns its report (nil for pauses).
+func (r *scenarioRunner) run(ctx context.Context, p *scenarioPhase) (*benchReport, error) {
+	uuids := r.phaseDevices(p)
+	report := &benchReport{
+		Name:      p.Name,
+		Operation: p.Type,
+		Started:   time.Now(),
+		Devices:   len(uuids),
+		Workers:   p.Workers,
+	}
+	timings := newReportTimings()
+	ctx = timings.context(ctx)
+	var op deviceOp
+	switch p.Type {
+	case "create":
+		report.Devices = p.Count
+		st := newStats()
+		for i := 0; i < p.Count && ctx.Err() == nil; i++ {
+			started := time.Now()
+			d, err := createDevice(r.rctx.DB, &p.deviceAttrs)
+			st.add(time.Since(started), err)
+			if err != nil {
+				return nil, err
+			}
+			r.uuids = append(r.uuids, d.UDID)
+		}
+		st.stop()
+		report.Summary = st.summary()
+		return report, nil
+	case "pause":
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Duration(p.Duration)):
+		}
+		return nil, nil
+	case "connect":
+		cwds := loadConnectWorkerData(r.rctx, uuids)
+		report.Devices = len(cwds)
+		if len(p.stages) > 0 {
+			report.Summary, report.Load = startConnectLoad(ctx, cwds, p.stages, p.Workers, time.Duration(p.MaxLateness), r.reqLog, r.progress)
+		} else {
+			report.Iterations = p.Iterations
+			report.Summary = startConnectWorkers(ctx, cwds, p.Workers, p.Iterations, r.reqLog, r.progress)
+		}
+		timings.finish(report)
+		return report, nil
+	case "install-profile":
+		op = func(ctx context.Context, udid string) error {
+			dev, err := r.rctx.LoadDevice(udid)
+			if err != nil {
+				return err
+			}
+			return dev.InstallProfile(ctx, p.profile)
+		}
+	case "token-update":
+		op = func(ctx context.Context, udid string) error {
+			dev, err := r.rctx.LoadDevice(udid)
+			if err != nil {
+				return err
+			}
+			client, err := dev.MDMClient()
+			if err != nil {
+				return err
+			}
+			return client.TokenUpdate(ctx, p.Addl)
+		}
+	case "ddm-sync":
+		op = ddmSyncOp(r.rctx)
+	case "ddm-status":
+		op = ddmStatusOp(r.rctx, p.StatusItems, p.Full)
+	case "remove-profile":
+		op = func(ctx context.Context, udid string) error {
+			dev, err := r.rctx.LoadDevice(udid)
+			if err != nil {
+				return err
+			}
+			return dev.RemoveProfile(ctx, p.Identifier)
+		}
+	default:
+		return nil, fmt.Errorf("unknown phase type: %q", p.Type)
+	}
+	report.Summary = startDeviceWorkers(ctx, uuids, p.Workers, scenarioOpNames[p.Type], op, r.reqLog, r.progress)
+	timings.finish(report)
+	return report, nil
+}
+
+func runScenario(name string, args []string, rctx RunContext, usage func()) {
+	f := flag.NewFlagSet(name, flag.ExitOnError)
+	var (
+		file       = f.String("f", "", "scenario file (JSON)")
+		reportOpts = &reportOptions{}
+		limits     = newThresholds()
+	)
+	reportOpts.addFlags(f)
+	limits.addFlags(f)
+	setSubCommandFlagSetUsage(f, usage)
+	f.Parse(args)
+
+	if *file == "" {
+		fmt.Fprintln(f.Output(), "must specify scenario file")
+		f.Usage()
+		os.Exit(2)
+	}
+	reportOpts.validateFlags(f)
+
+	sc, err := loadScenario(*file)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	reqLog, err := reportOpts.openLog()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	runner := &scenarioRunner{
+		rctx:     rctx,
+		uuids:    rctx.UUIDs,
+		reqLog:   reqLog,
+		progress: reportOpts.progress(),
+	}
+
+	report := &scenarioReport{Name: sc.Name, Started: time.Now()}
+	for _, p := range sc.Phases {
+		if rctx.Context.Err() != nil {
+			break
+		}
+		fmt.Fprintf(runner.progress, "== phase %s (%s)\n", p.Name, p.Type)
+		phaseReport, err := runner.run(withPhaseMetrics(rctx.Context, rctx.Metrics, p.Name), p)
+		if err != nil {
+			log.Fatal(fmt.Errorf("phase %s: %w", p.Name, err))
+		}
+		if phaseReport != nil {
+			// phase thresholds override those given on the command
+			// line which only apply to connect phases
+			var phaseLimits *thresholds
+			if p.Type == "connect" {
+				phaseLimits = limits
+			}
+			if p.Thresholds != nil {
+				phaseLimits = p.Thresholds
+			}
+			phaseReport.Thresholds = phaseLimits.check(phaseReport.Summary)
+			report.Phases = append(report.Phases, phaseReport)
+		}
+	}
+
+	if reqLog != nil {
+		if err = reqLog.close(); err != nil {
+			log.Println(err)
+		}
+	}
+	err = reportOpts.write(report, func(out io.Writer) {
+		for _, p := range report.Phases {
+			fmt.Fprintf(out, "== phase %s (%s)\n", p.Name, p.Operation)
+			p.print(out, scenarioOpNames[p.Operation])
+			fmt.Fprintln(out)
+		}
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	exitOnThresholdFailure(report.Phases...)
+}
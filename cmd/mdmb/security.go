@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	mathrand "math/rand"
+	"os"
+
+	"github.com/jessepeterson/mdmb/internal/device"
+)
+
+// devicesSecurity sets the security posture of devices reported in
+// SecurityInfo responses. Only the given settings are changed.
+func devicesSecurity(name string, args []string, rctx RunContext, usage func()) {
+	f := flag.NewFlagSet(name, flag.ExitOnError)
+	var (
+		passcode          = f.Bool("passcode", true, "passcode present")
+		passcodeCompliant = f.Bool("passcode-compliant", true, "passcode compliant")
+		passcodeProfiles  = f.Bool("passcode-compliant-profiles", true, "passcode compliant with profiles")
+		fileVault         = f.Bool("filevault", true, "FileVault enabled")
+		sip               = f.Bool("sip", true, "System Integrity Protection enabled")
+		firewall          = f.Bool("firewall", true, "firewall enabled")
+		firewallBlockAll  = f.Bool("firewall-block-all", false, "firewall blocks all incoming connections")
+		firewallStealth   = f.Bool("firewall-stealth", false, "firewall stealth mode")
+		secureBoot        = f.String("secure-boot", "full", "secure boot level: full, medium, or off")
+		encryptionCaps    = f.Int("encryption-caps", device.EncryptionBlockLevel|device.EncryptionFileLevel, "hardware encryption capabilities: 1 (block-level), 2 (file-level), or 3 (both)")
+		fraction          = f.Float64("fraction", 1, "change a random fraction (0 to 1) of the devices for a fleet with mixed postures")
+	)
+	setSubCommandFlagSetUsage(f, usage)
+	f.Parse(args)
+
+	if *secureBoot != "full" && *secureBoot != "medium" && *secureBoot != "off" {
+		fmt.Fprintf(f.Output(), "invalid secure boot level: %q\n", *secureBoot)
+		f.Usage()
+		os.Exit(2)
+	}
+
+	settings := 0
+	f.Visit(func(fl *flag.Flag) {
+		if fl.Name != "fraction" {
+			settings++
+		}
+	})
+	if settings < 1 {
+		fmt.Fprintln(f.Output(), "must specify security settings to change")
+		f.Usage()
+		os.Exit(2)
+	}
+
+	err := checkDeviceUUIDs(rctx, false, name)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	apply := func(sec *device.Security) {
+		f.Visit(func(fl *flag.Flag) {
+			switch fl.Name {
+			case "passcode":
+				sec.PasscodePresent = *passcode
+			case "passcode-compliant":
+				sec.PasscodeCompliant = *passcodeCompliant
+			case "passcode-compliant-profiles":
+				sec.PasscodeCompliantWithProfiles = *passcodeProfiles
+			case "filevault":
+				sec.FileVaultEnabled = *fileVault
+			case "sip":
+				sec.SIPEnabled = *sip
+			case "firewall":
+				sec.FirewallEnabled = *firewall
+			case "firewall-block-all":
+				sec.FirewallBlockAllIncoming = *firewallBlockAll
+			case "firewall-stealth":
+				sec.FirewallStealthMode = *firewallStealth
+			case "secure-boot":
+				sec.SecureBootLevel = *secureBoot
+			case "encryption-caps":
+				sec.HardwareEncryptionCaps = *encryptionCaps
+			}
+		})
+	}
+
+	for _, u := range rctx.UUIDs {
+		if *fraction < 1 && mathrand.Float64() >= *fraction {
+			continue
+		}
+		dev, err := rctx.LoadDevice(u)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		apply(&dev.Security)
+		if err = dev.Save(); err != nil {
+			log.Println(err)
+			continue
+		}
+		fmt.Println(u)
+	}
+}
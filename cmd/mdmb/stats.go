@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jessepeterson/mdmb/internal/device"
+)
+
+// latencyBuckets are the upper bounds of the latency histogram buckets.
+var latencyBuckets = []time.Duration{
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// reportedPercentiles are the latency percentiles included in summaries.
+var reportedPercentiles = []float64{50, 90, 95, 99, 99.9}
+
+// stats collects the elapsed times and errors of operations.
+// It is safe for concurrent use.
+type stats struct {
+	mu        sync.Mutex
+	started   time.Time
+	stopped   time.Time
+	durations []time.Duration // of successful operations
+	errCt     int
+	errCauses map[string]int
+}
+
+func newStats() *stats {
+	return &stats{
+		started:   time.Now(),
+		errCauses: make(map[string]int),
+	}
+}
+
+// add records the result of an operation that took d.
+func (s *stats) add(d time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.errCt++
+		s.errCauses[errorCause(err)]++
+		return
+	}
+	s.durations = append(s.durations, d)
+}
+
+// stop marks the end of the collection period for throughput.
+func (s *stats) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = time.Now()
+}
+
+// stageStats collects stats for each of the stages (SCEP operations
+// and check-in messages) of device operations, e.g. enrollment. It is
+// safe for concurrent use.
+type stageStats struct {
+	mu     sync.Mutex
+	stages []string // in the order first seen
+	stats  map[string]*stats
+}
+
+func newStageStats() *stageStats {
+	return &stageStats{stats: make(map[string]*stats)}
+}
+
+// add records the result of stage that took d.
+func (s *stageStats) add(stage string, d time.Duration, err error) {
+	s.mu.Lock()
+	st, ok := s.stats[stage]
+	if !ok {
+		st = newStats()
+		s.stats[stage] = st
+		s.stages = append(s.stages, stage)
+	}
+	s.mu.Unlock()
+	st.add(d, err)
+}
+
+// summaries stops collection and summarizes each stage.
+func (s *stageStats) summaries() []stageSummary {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var summaries []stageSummary
+	for _, stage := range s.stages {
+		st := s.stats[stage]
+		st.stop()
+		summaries = append(summaries, stageSummary{Stage: stage, Summary: st.summary()})
+	}
+	return summaries
+}
+
+// stageSummary is the summary of a single stage.
+type stageSummary struct {
+	Stage   string        `json:"stage"`
+	Summary *statsSummary `json:"summary"`
+}
+
+// printStageSummaries writes a human readable table of stage summaries.
+func printStageSummaries(out io.Writer, summaries []stageSummary) {
+	w := tabwriter.NewWriter(out, 4, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "Stage\tTotal\tErrors\tMean\tp50\tp95\tp99\tMax")
+	for _, s := range summaries {
+		ss := s.Summary
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\n",
+			s.Stage, ss.Total, ss.Errors, ss.Mean,
+			ss.Percentile(50), ss.Percentile(95), ss.Percentile(99), ss.Max)
+	}
+	w.Flush()
+}
+
+// errorCause classifies err into a short cause for reporting.
+func errorCause(err error) string {
+	var statusErr *device.HTTPStatusError
+	var netErr net.Error
+	var certErr x509.CertificateInvalidError
+	var unknownAuthErr x509.UnknownAuthorityError
+	var hostErr x509.HostnameError
+	switch {
+	case errors.As(err, &statusErr):
+		return fmt.Sprintf("HTTP %d", statusErr.StatusCode)
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.As(err, &certErr), errors.As(err, &unknownAuthErr), errors.As(err, &hostErr):
+		return "TLS certificate"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "connection reset"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &netErr):
+		return "network"
+	}
+	return "other"
+}
+
+// statsSummary is a point-in-time summary of collected stats.
+type statsSummary struct {
+	Total       int
+	Errors      int
+	ErrorCauses map[string]int
+	Elapsed     time.Duration
+	Throughput  float64 // operations per second
+
+	Min         time.Duration
+	Max         time.Duration
+	Mean        time.Duration
+	Stddev      time.Duration
+	Percentiles []statsPercentile
+	Histogram   []statsBucket
+}
+
+type statsPercentile struct {
+	Percentile float64
+	Value      time.Duration
+}
+
+type statsBucket struct {
+	UpperBound time.Duration // zero for +Inf
+	Count      int
+}
+
+// ErrorRate returns the percentage of operations that errored.
+func (ss *statsSummary) ErrorRate() float64 {
+	if ss.Total == 0 {
+		return 0
+	}
+	return float64(ss.Errors) * 100 / float64(ss.Total)
+}
+
+// Percentile returns the summarized latency percentile p.
+func (ss *statsSummary) Percentile(p float64) time.Duration {
+	for _, v := range ss.Percentiles {
+		if v.Percentile == p {
+			return v.Value
+		}
+	}
+	return 0
+}
+
+// percentile returns the nearest-rank percentile p of sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	// the epsilon guards against floating point error (e.g. p99.9)
+	rank := int(math.Ceil(p/100*float64(len(sorted)) - 1e-9))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func (s *stats) summary() *statsSummary {
+	s.mu.Lock()
+	sorted := make([]time.Duration, len(s.durations))
+	copy(sorted, s.durations)
+	ss := &statsSummary{
+		Total:       len(s.durations) + s.errCt,
+		Errors:      s.errCt,
+		ErrorCauses: make(map[string]int),
+	}
+	for k, v := range s.errCauses {
+		ss.ErrorCauses[k] = v
+	}
+	stopped := s.stopped
+	if stopped.IsZero() {
+		stopped = time.Now()
+	}
+	ss.Elapsed = stopped.Sub(s.started)
+	s.mu.Unlock()
+
+	if ss.Elapsed > 0 {
+		ss.Throughput = float64(ss.Total) / ss.Elapsed.Seconds()
+	}
+
+	for _, bound := range latencyBuckets {
+		ss.Histogram = append(ss.Histogram, statsBucket{UpperBound: bound})
+	}
+	ss.Histogram = append(ss.Histogram, statsBucket{})
+
+	if len(sorted) == 0 {
+		return ss
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var acc time.Duration
+	for _, d := range sorted {
+		acc += d
+		i := sort.Search(len(latencyBuckets), func(i int) bool { return d <= latencyBuckets[i] })
+		ss.Histogram[i].Count++
+	}
+	ss.Min = sorted[0]
+	ss.Max = sorted[len(sorted)-1]
+	ss.Mean = acc / time.Duration(len(sorted))
+
+	if len(sorted) > 1 {
+		var sd float64
+		for _, d := range sorted {
+			sd += math.Pow(float64(d)-float64(ss.Mean), 2)
+		}
+		ss.Stddev = time.Duration(math.Sqrt(sd / float64(len(sorted)-1)))
+	}
+
+	for _, p := range reportedPercentiles {
+		ss.Percentiles = append(ss.Percentiles, statsPercentile{
+			Percentile: p,
+			Value:      percentile(sorted, p),
+		})
+	}
+	return ss
+}
+
+// print writes a human readable summary. name describes the operation
+// (e.g. "MDM connect").
+func (ss *statsSummary) print(out io.Writer, name string) {
+	w := tabwriter.NewWriter(out, 4, 4, 4, ' ', 0)
+	fmt.Fprintf(w, "Total %ss\t%d\n", name, ss.Total)
+	fmt.Fprintf(w, "Errors\t%d (%.2f%%)\n", ss.Errors, ss.ErrorRate())
+	causes := make([]string, 0, len(ss.ErrorCauses))
+	for k := range ss.ErrorCauses {
+		causes = append(causes, k)
+	}
+	sort.Strings(causes)
+	for _, k := range causes {
+		fmt.Fprintf(w, "  %s\t%d\n", k, ss.ErrorCauses[k])
+	}
+	fmt.Fprintf(w, "Total elapsed time\t%s\n", ss.Elapsed)
+	fmt.Fprintf(w, "Throughput\t%.2f/s\n", ss.Throughput)
+	fmt.Fprintf(w, "Min %s elapsed\t%s\n", name, ss.Min)
+	fmt.Fprintf(w, "Max %s elapsed\t%s\n", name, ss.Max)
+	fmt.Fprintf(w, "Avg (mean) %s elapsed\t%s\n", name, ss.Mean)
+	fmt.Fprintf(w, "Stddev %s elapsed\t%s\n", name, ss.Stddev)
+	for _, p := range ss.Percentiles {
+		fmt.Fprintf(w, "p%s %s elapsed\t%s\n", formatPercentile(p.Percentile), name, p.Value)
+	}
+	w.Flush()
+
+	if ss.Total-ss.Errors < 1 {
+		return
+	}
+	fmt.Fprintf(out, "\n%s elapsed histogram:\n", name)
+	w = tabwriter.NewWriter(out, 4, 4, 2, ' ', 0)
+	maxCt := 0
+	for _, b := range ss.Histogram {
+		if b.Count > maxCt {
+			maxCt = b.Count
+		}
+	}
+	for _, b := range ss.Histogram {
+		bound := "+Inf"
+		if b.UpperBound > 0 {
+			bound = b.UpperBound.String()
+		}
+		bar := strings.Repeat("#", b.Count*40/maxCt)
+		fmt.Fprintf(w, "<= %s\t%d\t%s\n", bound, b.Count, bar)
+	}
+	w.Flush()
+}
+
+func formatPercentile(p float64) string {
+	return strings.TrimSuffix(fmt.Sprintf("%.1f", p), ".0")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jessepeterson/mdmb/internal/device"
+)
+
+func TestStatsSummary(t *testing.T) {
+	st := newStats()
+	for i := 1; i <= 1000; i++ {
+		st.add(time.Duration(i)*time.Millisecond, nil)
+	}
+	st.add(time.Second, &device.HTTPStatusError{StatusCode: 500})
+	st.add(time.Second, errors.New("test error"))
+	st.stop()
+	ss := st.summary()
+
+	if have, want := ss.Total, 1002; have != want {
+		t.Errorf("total: have %d, want %d", have, want)
+	}
+	if have, want := ss.Errors, 2; have != want {
+		t.Errorf("errors: have %d, want %d", have, want)
+	}
+	if have, want := ss.ErrorCauses["HTTP 500"], 1; have != want {
+		t.Errorf("HTTP 500 error causes: have %d, want %d", have, want)
+	}
+	if have, want := ss.ErrorCauses["other"], 1; have != want {
+		t.Errorf("other error causes: have %d, want %d", have, want)
+	}
+	for _, test := range []struct {
+		p    float64
+		want time.Duration
+	}{
+		{50, 500 * time.Millisecond},
+		{90, 900 * time.Millisecond},
+		{99, 990 * time.Millisecond},
+		{99.9, 999 * time.Millisecond},
+	} {
+		if have := ss.Percentile(test.p); have != test.want {
+			t.Errorf("p%v: have %s, want %s", test.p, have, test.want)
+		}
+	}
+	if have, want := ss.Min, time.Millisecond; have != want {
+		t.Errorf("min: have %s, want %s", have, want)
+	}
+	if have, want := ss.Max, time.Second; have != want {
+		t.Errorf("max: have %s, want %s", have, want)
+	}
+	var histCt int
+	for _, b := range ss.Histogram {
+		histCt += b.Count
+	}
+	if have, want := histCt, 1000; have != want {
+		t.Errorf("histogram count: have %d, want %d", have, want)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// thresholds are pass/fail assertions on a benchmark summary. Zero
+// (or, for the error rate, negative) values are not checked.
+type thresholds struct {
+	MaxErrorRate  float64       `json:"max_error_rate"` // percent
+	MaxP50        durationValue `json:"max_p50"`
+	MaxP90        durationValue `json:"max_p90"`
+	MaxP95        durationValue `json:"max_p95"`
+	MaxP99        durationValue `json:"max_p99"`
+	MinThroughput float64       `json:"min_throughput"` // per second
+}
+
+func newThresholds() *thresholds {
+	return &thresholds{MaxErrorRate: -1}
+}
+
+// UnmarshalJSON decodes thresholds leaving the error rate unchecked
+// when not specified.
+func (t *thresholds) UnmarshalJSON(b []byte) error {
+	type plain thresholds
+	p := (*plain)(newThresholds())
+	if err := json.Unmarshal(b, p); err != nil {
+		return err
+	}
+	*t = thresholds(*p)
+	return nil
+}
+
+func (t *thresholds) addFlags(f *flag.FlagSet) {
+	f.Float64Var(&t.MaxErrorRate, "max-error-rate", t.MaxErrorRate, "fail if the error rate percentage is above this (negative to disable)")
+	f.Var(&t.MaxP50, "max-p50", "fail if p50 latency is above this duration")
+	f.Var(&t.MaxP90, "max-p90", "fail if p90 latency is above this duration")
+	f.Var(&t.MaxP95, "max-p95", "fail if p95 latency is above this duration")
+	f.Var(&t.MaxP99, "max-p99", "fail if p99 latency is above this duration")
+	f.Float64Var(&t.MinThroughput, "min-throughput", 0, "fail if throughput (per second) is below this")
+}
+
+// thresholdResult is the outcome of checking a single threshold.
+type thresholdResult struct {
+	Threshold string  `json:"threshold"`
+	Limit     float64 `json:"limit"`
+	Value     float64 `json:"value"`
+	Passed    bool    `json:"passed"`
+
+	desc string // human readable
+}
+
+// check evaluates the thresholds against ss. Only the configured
+// thresholds are returned.
+func (t *thresholds) check(ss *statsSummary) (results []thresholdResult) {
+	if t == nil || ss == nil {
+		return
+	}
+	if t.MaxErrorRate >= 0 && ss.Total < 1 {
+		results = append(results, thresholdResult{
+			Threshold: "max_error_rate_percent",
+			Limit:     t.MaxErrorRate,
+			desc: fmt.Sprintf(
+				"error rate n/a: no operations (max %s%%)",
+				strconv.FormatFloat(t.MaxErrorRate, 'f', -1, 64),
+			),
+		})
+	} else if t.MaxErrorRate >= 0 {
+		rate := ss.ErrorRate()
+		results = append(results, thresholdResult{
+			Threshold: "max_error_rate_percent",
+			Limit:     t.MaxErrorRate,
+			Value:     rate,
+			Passed:    rate <= t.MaxErrorRate,
+			desc: fmt.Sprintf(
+				"error rate %s%% (max %s%%)",
+				strconv.FormatFloat(rate, 'f', -1, 64),
+				strconv.FormatFloat(t.MaxErrorRate, 'f', -1, 64),
+			),
+		})
+	}
+	for _, l := range []struct {
+		p   float64
+		max durationValue
+	}{{50, t.MaxP50}, {90, t.MaxP90}, {95, t.MaxP95}, {99, t.MaxP99}} {
+		if l.max <= 0 {
+			continue
+		}
+		name := "p" + formatPercentile(l.p)
+		if ss.Total-ss.Errors < 1 {
+			// latencies are of successful operations only
+			results = append(results, thresholdResult{
+				Threshold: "max_" + name + "_ms",
+				Limit:     durationMs(time.Duration(l.max)),
+				desc:      fmt.Sprintf("%s latency n/a: no successful operations (max %s)", name, time.Duration(l.max)),
+			})
+			continue
+		}
+		v := ss.Percentile(l.p)
+		results = append(results, thresholdResult{
+			Threshold: "max_" + name + "_ms",
+			Limit:     durationMs(time.Duration(l.max)),
+			Value:     durationMs(v),
+			Passed:    v <= time.Duration(l.max),
+			desc:      fmt.Sprintf("%s latency %s (max %s)", name, v, time.Duration(l.max)),
+		})
+	}
+	if t.MinThroughput > 0 {
+		results = append(results, thresholdResult{
+			Threshold: "min_throughput_per_second",
+			Limit:     t.MinThroughput,
+			Value:     ss.Throughput,
+			Passed:    ss.Throughput >= t.MinThroughput,
+			desc:      fmt.Sprintf("throughput %.2f/s (min %.2f/s)", ss.Throughput, t.MinThroughput),
+		})
+	}
+	return
+}
+
+func printThresholdResults(out io.Writer, results []thresholdResult) {
+	for _, r := range results {
+		result := "PASS"
+		if !r.Passed {
+			result = "FAIL"
+		}
+		fmt.Fprintf(out, "%s  %s\n", result, r.desc)
+	}
+}
+
+// exitOnThresholdFailure reports failed thresholds of reports to stderr
+// and exits with a non-zero status if there are any.
+func exitOnThresholdFailure(reports ...*benchReport) {
+	failed := 0
+	for _, r := range reports {
+		for _, tr := range r.Thresholds {
+			if tr.Passed {
+				continue
+			}
+			failed++
+			if r.Name != "" {
+				fmt.Fprintf(os.Stderr, "threshold failed: phase %s: %s\n", r.Name, tr.desc)
+			} else {
+				fmt.Fprintf(os.Stderr, "threshold failed: %s\n", tr.desc)
+			}
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d threshold(s) failed\n", failed)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestThresholdsWithoutOperations(t *testing.T) {
+	limits := newThresholds()
+	limits.MaxErrorRate = 5
+	limits.MaxP95 = durationValue(time.Second)
+
+	empty := newStats()
+	empty.stop()
+	failed := newStats()
+	failed.add(time.Millisecond, errors.New("test error"))
+	failed.stop()
+
+	for _, test := range []struct {
+		name string
+		ss   *statsSummary
+		want []bool // error rate, p95
+	}{
+		{"no operations", empty.summary(), []bool{false, false}},
+		{"all failed", failed.summary(), []bool{false, false}},
+	} {
+		results := limits.check(test.ss)
+		if have, want := len(results), len(test.want); have != want {
+			t.Fatalf("%s: have %d results, want %d", test.name, have, want)
+		}
+		for i, r := range results {
+			if r.Passed != test.want[i] {
+				t.Errorf("%s: %s: have passed %v, want %v", test.name, r.desc, r.Passed, test.want[i])
+			}
+		}
+	}
+}
@@ -4,12 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
-	"math"
-	"os"
 	"sync"
-	"text/tabwriter"
-	"time"
 
 	"github.com/jessepeterson/mdmb/internal/device"
 )
@@ -19,6 +16,37 @@ type ConnectWorkerData struct {
 	MDMClient *device.MDMClient
 }
 
+type connectWorkItem struct {
+	*ConnectWorkerData
+	iteration int
+}
+
+// loadConnectWorkerData loads the enrolled devices of uuids for connecting.
+func loadConnectWorkerData(rctx RunContext, uuids []string) []*ConnectWorkerData {
+	workerData := []*ConnectWorkerData{}
+
+	for _, u := range uuids {
+		dev, err := rctx.LoadDevice(u)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		client, err := dev.MDMClient()
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		workerData = append(workerData, &ConnectWorkerData{
+			Device:    dev,
+			MDMClient: client,
+		})
+	}
+
+	return workerData
+}
+
 func connectWork(ctx context.Context, cwd *ConnectWorkerData) error {
 	if cwd.MDMClient == nil || cwd.Device == nil {
 		return errors.New("invalid mdm client or device")
@@ -26,81 +54,95 @@ func connectWork(ctx context.Context, cwd *ConnectWorkerData) error {
 	return cwd.MDMClient.Connect(ctx)
 }
 
-func startConnectWorkers(ctx context.Context, cwds []*ConnectWorkerData, workers, iterations int) {
+// deviceOp is an operation on a device, e.g. installing a profile.
+type deviceOp func(ctx context.Context, udid string) error
+
+// recordedWork performs op on a device recording the result into st
+// and reqLog (if not nil) and writing progress to progress.
+func recordedWork(ctx context.Context, udid string, iteration int, opName string, op deviceOp, st *stats, reqLog requestLog, progress io.Writer) {
+	rctx, rec := newRequestRecord(ctx, udid, iteration)
+	err := op(rctx, udid)
+	d := rec.finish(err)
+	st.add(d, err)
+	contextPhaseMetrics(ctx).operation(opName, d, err)
+	if reqLog != nil {
+		if err := reqLog.write(rec); err != nil {
+			log.Println(fmt.Errorf("writing request log: %w", err))
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(progress)
+		log.Println(fmt.Errorf("%s for device %s: %w", opName, udid, err))
+	} else {
+		fmt.Fprint(progress, ".")
+	}
+}
+
+// recordedConnectWork connects a device like recordedWork.
+func recordedConnectWork(ctx context.Context, cwd *ConnectWorkerData, iteration int, st *stats, reqLog requestLog, progress io.Writer) {
+	op := func(ctx context.Context, _ string) error {
+		return connectWork(ctx, cwd)
+	}
+	recordedWork(ctx, cwd.Device.UDID, iteration, "device connect", op, st, reqLog, progress)
+}
+
+// startConnectWorkers connects devices for a number of iterations
+// using a pool of workers. Records of each connect are written to
+// reqLog if not nil and progress is written to progress.
+func startConnectWorkers(ctx context.Context, cwds []*ConnectWorkerData, workers, iterations int, reqLog requestLog, progress io.Writer) *statsSummary {
 	var wg sync.WaitGroup
-	queue := make(chan *ConnectWorkerData, workers)
-	var (
-		totalCt int
-		errCt   int
-		durrAcc time.Duration
-		durrLow time.Duration
-		durrHi  time.Duration
-	)
-	durrVals := make([]time.Duration, iterations*len(cwds))
-	fmt.Printf("starting %d workers for %d iterations of %d devices (%d connects)\n", workers, iterations, len(cwds), len(cwds)*iterations)
+	queue := make(chan connectWorkItem, workers)
+	st := newStats()
+	fmt.Fprintf(progress, "starting %d workers for %d iterations of %d devices (%d connects)\n", workers, iterations, len(cwds), len(cwds)*iterations)
 	for i := 0; i < workers; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for cwd := range queue {
-				totalCt++
-				started := time.Now()
-				err := connectWork(ctx, cwd)
-				d := time.Since(started)
-				durrVals[totalCt-1] = d
-				if err != nil {
-					errCt++
-					fmt.Println()
-					log.Println(fmt.Errorf("device connect for device %s: %w", cwd.Device.UDID, err))
-					continue
-				} else {
-					fmt.Print(".")
-				}
-				durrAcc += d
-				if durrLow == 0 {
-					durrLow = d
-				}
-				if durrHi == 0 {
-					durrHi = d
-				}
-				if d < durrLow {
-					durrLow = d
-				}
-				if d > durrHi {
-					durrHi = d
-				}
+			for item := range queue {
+				recordedConnectWork(ctx, item.ConnectWorkerData, item.iteration, st, reqLog, progress)
 			}
 		}()
 	}
-	start := time.Now()
 	for i := 0; i < iterations; i++ {
 		for _, cwd := range cwds {
-			queue <- cwd
+			queue <- connectWorkItem{ConnectWorkerData: cwd, iteration: i + 1}
 		}
 	}
 	close(queue)
 	wg.Wait()
-	fmt.Print("\n\n")
-
-	var durrSd float64
-	var mean time.Duration
-	var errors int
-	if totalCt > 0 {
-		mean = durrAcc / time.Duration(totalCt)
-		errors = (errCt * 100) / totalCt
+	st.stop()
+	fmt.Fprint(progress, "\n\n")
+
+	return st.summary()
+}
+
+// startDeviceWorkers performs op once on each device using a pool of
+// workers. Records of each op are written to reqLog if not nil and
+// progress is written to progress.
+func startDeviceWorkers(ctx context.Context, uuids []string, workers int, opName string, op deviceOp, reqLog requestLog, progress io.Writer) *statsSummary {
+	var wg sync.WaitGroup
+	queue := make(chan string, workers)
+	st := newStats()
+	fmt.Fprintf(progress, "starting %d workers for %d devices\n", workers, len(uuids))
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for udid := range queue {
+				recordedWork(ctx, udid, 1, opName, op, st, reqLog, progress)
+			}
+		}()
 	}
-	for _, v := range durrVals {
-		durrSd += math.Pow(float64(v)-float64(mean), 2)
+	for _, udid := range uuids {
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- udid
 	}
-	durrSd = math.Sqrt(durrSd / 10)
-
-	w := tabwriter.NewWriter(os.Stdout, 4, 4, 4, ' ', 0)
-	fmt.Fprintf(w, "Total MDM connects\t%d (%d%%)\n", totalCt, 100)
-	fmt.Fprintf(w, "Errors\t%d (%d%%)\n", errCt, errors)
-	fmt.Fprintf(w, "Total elapsed time\t%s\n", time.Since(start))
-	fmt.Fprintf(w, "Min MDM connect elapsed\t%s\n", durrLow)
-	fmt.Fprintf(w, "Max MDM connect elapsed\t%s\n", durrHi)
-	fmt.Fprintf(w, "Avg (mean) MDM connect elapsed\t%s\n", mean)
-	fmt.Fprintf(w, "Stddev MDM connect elapsed\t%s\n", time.Duration(durrSd))
-	w.Flush()
+	close(queue)
+	wg.Wait()
+	st.stop()
+	fmt.Fprint(progress, "\n\n")
+
+	return st.summary()
 }
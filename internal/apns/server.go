@@ -0,0 +1,134 @@
+// Package apns implements a stand-in for the APNs HTTP/2 provider API.
+// It allows MDM servers to "push" simulated devices.
+package apns
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MaxPayloadSize is the maximum size of an APNs notification payload.
+const MaxPayloadSize = 4096
+
+var (
+	// ErrBadDeviceToken indicates the device token is unknown.
+	ErrBadDeviceToken = errors.New("bad device token")
+
+	// ErrUnregistered indicates the device token is no longer active.
+	ErrUnregistered = errors.New("device token unregistered")
+
+	// ErrTopicMismatch indicates the device token is not for the topic.
+	ErrTopicMismatch = errors.New("device token not for topic")
+)
+
+// Notification is an MDM push notification sent to a device.
+type Notification struct {
+	ID        string
+	Token     string // hex-encoded
+	Topic     string
+	PushMagic string
+}
+
+// Notifier delivers a push notification to a device.
+type Notifier func(context.Context, *Notification) error
+
+// Server handles APNs provider API requests.
+type Server struct {
+	notify Notifier
+}
+
+// New creates a new APNs stand-in server that delivers notifications to n.
+func New(n Notifier) *Server {
+	return &Server{notify: n}
+}
+
+type errorResponse struct {
+	Reason    string `json:"reason"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+}
+
+func writeError(w http.ResponseWriter, status int, reason string) {
+	resp := &errorResponse{Reason: reason}
+	if status == http.StatusGone {
+		resp.Timestamp = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+type mdmPayload struct {
+	MDM string `json:"mdm"`
+}
+
+// ServeHTTP handles "POST /3/device/<token>" APNs provider API requests.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := &Notification{
+		ID:    r.Header.Get("apns-id"),
+		Topic: r.Header.Get("apns-topic"),
+	}
+	if n.ID == "" {
+		n.ID = strings.ToUpper(uuid.NewString())
+	}
+	w.Header().Set("apns-id", n.ID)
+
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/3/device/") {
+		writeError(w, http.StatusNotFound, "BadPath")
+		return
+	}
+	n.Token = strings.ToLower(strings.TrimPrefix(r.URL.Path, "/3/device/"))
+	if _, err := hex.DecodeString(n.Token); n.Token == "" || err != nil {
+		writeError(w, http.StatusBadRequest, "BadDeviceToken")
+		return
+	}
+	if n.Topic == "" {
+		writeError(w, http.StatusBadRequest, "MissingTopic")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, MaxPayloadSize+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "BadPayload")
+		return
+	}
+	if len(body) > MaxPayloadSize {
+		writeError(w, http.StatusRequestEntityTooLarge, "PayloadTooLarge")
+		return
+	}
+	if len(body) == 0 {
+		writeError(w, http.StatusBadRequest, "PayloadEmpty")
+		return
+	}
+	pld := &mdmPayload{}
+	if err := json.Unmarshal(body, pld); err != nil {
+		writeError(w, http.StatusBadRequest, "BadPayload")
+		return
+	}
+	n.PushMagic = pld.MDM
+
+	err = s.notify(r.Context(), n)
+	switch {
+	case errors.Is(err, ErrBadDeviceToken):
+		writeError(w, http.StatusBadRequest, "BadDeviceToken")
+	case errors.Is(err, ErrTopicMismatch):
+		writeError(w, http.StatusBadRequest, "DeviceTokenNotForTopic")
+	case errors.Is(err, ErrUnregistered):
+		writeError(w, http.StatusGone, "Unregistered")
+	case err != nil:
+		log.Printf("apns notification %s: %v", n.ID, err)
+		writeError(w, http.StatusInternalServerError, "InternalServerError")
+	}
+}
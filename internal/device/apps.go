@@ -0,0 +1,84 @@
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// App is an application installed on a device.
+type App struct {
+	Identifier   string `json:"identifier"` // bundle ID
+	Version      string `json:"version"`
+	ShortVersion string `json:"short_version"`
+	Name         string `json:"name"`
+	BundleSize   int64  `json:"size"`
+	Managed      bool   `json:"managed"`
+}
+
+// DefaultApps are installed on new devices if no other apps are given.
+var DefaultApps = []App{
+	{Identifier: "com.apple.Safari", Version: "20621.1.15.11.10", ShortVersion: "18.4", Name: "Safari", BundleSize: 14502912},
+	{Identifier: "com.apple.iWork.Keynote", Version: "7038.0.80", ShortVersion: "14.4", Name: "Keynote", BundleSize: 712466432},
+	{Identifier: "com.apple.iWork.Numbers", Version: "7038.0.80", ShortVersion: "14.4", Name: "Numbers", BundleSize: 536944640},
+	{Identifier: "com.apple.iWork.Pages", Version: "7038.0.80", ShortVersion: "14.4", Name: "Pages", BundleSize: 604004352},
+}
+
+func (device *Device) appKey(identifier string) string {
+	return fmt.Sprintf("%s_%s", device.UDID, identifier)
+}
+
+// InstallApp installs (or replaces) app on the device.
+func (device *Device) InstallApp(app *App) error {
+	if app.Identifier == "" {
+		return errors.New("app has no identifier")
+	}
+	ab, err := json.Marshal(app)
+	if err != nil {
+		return err
+	}
+	return device.boltDB.Update(func(tx *bolt.Tx) error {
+		return BucketPutOrDelete(tx, "device_apps", device.appKey(app.Identifier), ab)
+	})
+}
+
+// RemoveApp removes the app with identifier from the device.
+func (device *Device) RemoveApp(identifier string) error {
+	return device.boltDB.Update(func(tx *bolt.Tx) error {
+		return BucketPutOrDelete(tx, "device_apps", device.appKey(identifier), nil)
+	})
+}
+
+// App returns the installed app with identifier or nil if it is not
+// installed.
+func (device *Device) App(identifier string) (app *App, err error) {
+	var ab []byte
+	err = device.boltDB.View(func(tx *bolt.Tx) error {
+		ab = BucketGet(tx, "device_apps", device.appKey(identifier))
+		return nil
+	})
+	if err != nil || len(ab) == 0 {
+		return
+	}
+	app = &App{}
+	err = json.Unmarshal(ab, app)
+	return
+}
+
+// Apps returns the installed apps of the device ordered by identifier.
+func (device *Device) Apps() (apps []*App, err error) {
+	err = device.boltDB.View(func(tx *bolt.Tx) error {
+		prefix := device.UDID + "_"
+		for _, id := range BucketGetKeysWithPrefix(tx, "device_apps", prefix, true) {
+			app := &App{}
+			if err := json.Unmarshal(BucketGet(tx, "device_apps", prefix+id), app); err != nil {
+				return fmt.Errorf("app %s: %w", id, err)
+			}
+			apps = append(apps, app)
+		}
+		return nil
+	})
+	return
+}
@@ -54,6 +54,35 @@ func BucketGetInt(tx *bolt.Tx, bucket, key string) int {
 
 }
 
+// BucketPutOrDeleteFloat Puts a value to a BoltDB bucket. If the value is 0 the key is Deleted.
+func BucketPutOrDeleteFloat(tx *bolt.Tx, bucket, key string, value float64) error {
+	var byteValue []byte
+	if value != 0 {
+		byteValue = []byte(strconv.FormatFloat(value, 'g', -1, 64))
+	}
+	return BucketPutOrDelete(tx, bucket, key, byteValue)
+}
+
+// BucketGetFloat retrieves a value from a bucket or returns 0.
+func BucketGetFloat(tx *bolt.Tx, bucket, key string) float64 {
+	f, _ := strconv.ParseFloat(string(BucketGet(tx, bucket, key)), 64)
+	return f
+}
+
+// BucketPutOrDeleteBool Puts a value to a BoltDB bucket. If the value is false the key is Deleted.
+func BucketPutOrDeleteBool(tx *bolt.Tx, bucket, key string, value bool) error {
+	var byteValue []byte
+	if value {
+		byteValue = []byte("1")
+	}
+	return BucketPutOrDelete(tx, bucket, key, byteValue)
+}
+
+// BucketGetBool retrieves a value from a bucket or returns false.
+func BucketGetBool(tx *bolt.Tx, bucket, key string) bool {
+	return len(BucketGet(tx, bucket, key)) > 0
+}
+
 // BucketGetKeysWithPrefix retrieves a list of keys with a prefix in a bucket
 func BucketGetKeysWithPrefix(tx *bolt.Tx, bucket string, prefix string, stripPrefix bool) []string {
 	b := tx.Bucket([]byte(bucket))
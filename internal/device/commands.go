@@ -2,6 +2,7 @@ package device
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strings"
 
@@ -10,13 +11,8 @@ import (
 )
 
 func (c *MDMClient) handleMDMCommand(ctx context.Context, reqType, commandUUID string, respBytes []byte) (interface{}, error) {
-	if c.notNow {
-		return &ConnectRequest{
-			UDID:        c.Device.UDID,
-			CommandUUID: commandUUID,
-			Status:      "NotNow",
-			RequestType: reqType,
-		}, nil
+	if resp, err := c.applyResponsePolicy(ctx, reqType, commandUUID); resp != nil || err != nil {
+		return resp, err
 	}
 
 	switch reqType {
@@ -26,21 +22,46 @@ func (c *MDMClient) handleMDMCommand(ctx context.Context, reqType, commandUUID s
 		return c.handleProfileList(reqType, commandUUID)
 	case "InstallProfile":
 		return c.handleInstallProfile(ctx, respBytes)
+	case "RemoveProfile":
+		return c.handleRemoveProfile(ctx, respBytes)
+	case "CertificateList":
+		return c.handleCertificateList(respBytes)
+	case "InstalledApplicationList":
+		return c.handleInstalledApplicationList(respBytes)
+	case "InstallApplication":
+		return c.handleInstallApplication(ctx, respBytes)
+	case "RemoveApplication":
+		return c.handleRemoveApplication(respBytes)
+	case "ManagedApplicationList":
+		return c.handleManagedApplicationList(respBytes)
+	case "SecurityInfo":
+		return c.handleSecurityInfo(reqType, commandUUID)
+	case "DeclarativeManagement":
+		return c.handleDeclarativeManagement(respBytes)
+	case "AvailableOSUpdates":
+		return c.handleAvailableOSUpdates(reqType, commandUUID)
+	case "ScheduleOSUpdate":
+		return c.handleScheduleOSUpdate(respBytes)
+	case "OSUpdateStatus":
+		return c.handleOSUpdateStatus(reqType, commandUUID)
 	default:
 		fmt.Printf("MDM command not handled: %s UUID %s\n", reqType, commandUUID)
-		return &ConnectRequest{
-			UDID:        c.Device.UDID,
-			CommandUUID: commandUUID,
-			RequestType: reqType,
-			Status:      "Error",
-			ErrorChain: []ErrorChain{
-				{
-					ErrorCode:            12021,
-					ErrorDomain:          "MCMDMErrorDomain",
-					LocalizedDescription: fmt.Sprintf("Unknown command: %s <MDMClientError:91>", reqType),
-				},
-			},
-		}, nil
+		return c.errorResponse(reqType, commandUUID, ErrorChain{
+			ErrorCode:            12021,
+			ErrorDomain:          "MCMDMErrorDomain",
+			LocalizedDescription: fmt.Sprintf("Unknown command: %s <MDMClientError:91>", reqType),
+		}), nil
+	}
+}
+
+// errorResponse creates an Error status command response.
+func (c *MDMClient) errorResponse(reqType, commandUUID string, errorChain ...ErrorChain) *ConnectRequest {
+	return &ConnectRequest{
+		UDID:        c.Device.UDID,
+		CommandUUID: commandUUID,
+		RequestType: reqType,
+		Status:      "Error",
+		ErrorChain:  errorChain,
 	}
 }
 
@@ -56,7 +77,36 @@ type DeviceInfo struct {
 
 type DeviceInfoResponse struct {
 	ConnectRequest
-	QueryResponses map[string]string
+	QueryResponses map[string]interface{}
+}
+
+// deviceInfo returns the answers to all supported DeviceInformation
+// queries.
+func (device *Device) deviceInfo() map[string]interface{} {
+	hw := &device.Hardware
+	ethernetMACs := hw.EthernetMACs
+	if ethernetMACs == nil {
+		ethernetMACs = []string{}
+	}
+	return map[string]interface{}{
+		"AvailableDeviceCapacity": hw.AvailableDeviceCapacity,
+		"BatteryLevel":            hw.BatteryLevel,
+		"BluetoothMAC":            hw.BluetoothMAC,
+		"BuildVersion":            device.BuildVersion,
+		"DeviceCapacity":          hw.DeviceCapacity,
+		"DeviceName":              device.ComputerName,
+		"EthernetMACs":            ethernetMACs,
+		"IsActivationLockEnabled": hw.IsActivationLockEnabled,
+		"IsSupervised":            hw.IsSupervised,
+		"Model":                   hw.Model,
+		"ModelName":               device.ModelName(),
+		"ModelNumber":             hw.Model + "LL/A",
+		"OSVersion":               device.OSVersion,
+		"ProductName":             device.ProductName,
+		"SerialNumber":            device.Serial,
+		"UDID":                    device.UDID,
+		"WiFiMAC":                 hw.WiFiMAC,
+	}
 }
 
 func (c *MDMClient) handleDeviceInfo(respBytes []byte) (interface{}, error) {
@@ -72,31 +122,28 @@ func (c *MDMClient) handleDeviceInfo(respBytes []byte) (interface{}, error) {
 			CommandUUID: cmd.CommandUUID,
 			RequestType: cmd.Command.RequestType,
 		},
-		QueryResponses: make(map[string]string),
+		QueryResponses: make(map[string]interface{}),
 	}
 	// TODO: check MDM enrollment permission bits in all of this?
+	info := c.Device.deviceInfo()
 	queries := cmd.Command.Queries
 	if len(queries) == 0 {
-		queries = []string{
-			"DeviceName",
-			"SerialNumber",
-			"UDID",
+		// answer all supported queries
+		for k := range info {
+			queries = append(queries, k)
 		}
 	}
 	var unknownQueries []string
 	for _, v := range queries {
-		switch v {
-		case "DeviceName":
-			resp.QueryResponses[v] = c.Device.ComputerName
-		case "SerialNumber":
-			resp.QueryResponses[v] = c.Device.Serial
-		case "UDID":
-			resp.QueryResponses[v] = c.Device.UDID
-		default:
+		if answer, ok := info[v]; ok {
+			resp.QueryResponses[v] = answer
+		} else {
 			unknownQueries = append(unknownQueries, v)
 		}
 	}
-	fmt.Printf("unknown DeviceInfo queries: %s\n", strings.Join(unknownQueries, ", "))
+	if len(unknownQueries) > 0 {
+		fmt.Printf("unknown DeviceInfo queries: %s\n", strings.Join(unknownQueries, ", "))
+	}
 	return resp, nil
 }
 
@@ -207,3 +254,667 @@ func (c *MDMClient) handleInstallProfile(ctx context.Context, respBytes []byte)
 	}
 	return resp, nil
 }
+
+type RemoveProfileCommand struct {
+	ConnectResponseCommand
+	Identifier string
+}
+
+type RemoveProfile struct {
+	Command     RemoveProfileCommand
+	CommandUUID string
+}
+
+func (c *MDMClient) handleRemoveProfile(ctx context.Context, respBytes []byte) (interface{}, error) {
+	cmd := &RemoveProfile{}
+	err := plist.Unmarshal(respBytes, cmd)
+	if err != nil {
+		return nil, err
+	}
+	reqType, id := cmd.Command.RequestType, cmd.Command.Identifier
+	if id == "" {
+		return c.errorResponse(reqType, cmd.CommandUUID, ErrorChain{
+			ErrorCode:            12001,
+			ErrorDomain:          "MCMDMErrorDomain",
+			LocalizedDescription: "Invalid request: missing Identifier",
+		}), nil
+	}
+	if _, err := c.Device.SystemProfileStore().Load(id); err != nil {
+		return c.errorResponse(reqType, cmd.CommandUUID, ErrorChain{
+			ErrorCode:            1004,
+			ErrorDomain:          "MCProfileErrorDomain",
+			LocalizedDescription: fmt.Sprintf("The profile “%s” is not installed.", id),
+		}), nil
+	}
+
+	// removing the enrollment profile unenrolls (and checks out)
+	if id == c.Device.MDMProfileIdentifier {
+		if err = c.Device.RemoveProfile(ctx, id); err != nil {
+			return nil, err
+		}
+		return nil, errUnenrolled
+	}
+
+	byMDM, err := c.Device.InstalledByMDM(id)
+	if err != nil {
+		return nil, err
+	}
+	if !byMDM {
+		return c.errorResponse(reqType, cmd.CommandUUID, ErrorChain{
+			ErrorCode:            12023,
+			ErrorDomain:          "MCMDMErrorDomain",
+			LocalizedDescription: fmt.Sprintf("The profile “%s” was not installed by MDM and cannot be removed.", id),
+		}), nil
+	}
+
+	if err = c.Device.RemoveProfile(ctx, id); err != nil {
+		return nil, err
+	}
+	return &ConnectRequest{
+		UDID:        c.Device.UDID,
+		Status:      "Acknowledged",
+		CommandUUID: cmd.CommandUUID,
+		RequestType: reqType,
+	}, nil
+}
+
+type CertificateListCommand struct {
+	ConnectResponseCommand
+	ManagedOnly bool `plist:",omitempty"`
+}
+
+type CertificateList struct {
+	Command     CertificateListCommand
+	CommandUUID string
+}
+
+type CertificateListResponse struct {
+	ConnectRequest
+	CertificateList []certificateListItem
+}
+
+type certificateListItem struct {
+	CommonName string
+	Data       []byte
+	IsIdentity bool
+}
+
+func (c *MDMClient) handleCertificateList(respBytes []byte) (interface{}, error) {
+	cmd := &CertificateList{}
+	err := plist.Unmarshal(respBytes, cmd)
+	if err != nil {
+		return nil, err
+	}
+	resp := &CertificateListResponse{
+		ConnectRequest: ConnectRequest{
+			UDID:        c.Device.UDID,
+			Status:      "Acknowledged",
+			CommandUUID: cmd.CommandUUID,
+			RequestType: cmd.Command.RequestType,
+		},
+		CertificateList: []certificateListItem{},
+	}
+	kc := c.Device.SystemKeychain()
+	uuids, err := kc.ListItemUUIDs()
+	if err != nil {
+		return nil, err
+	}
+	var certs []*KeychainItem
+	identityCerts := make(map[string]bool)
+	for _, uuid := range uuids {
+		kci, err := LoadKeychainItem(kc, uuid)
+		if err != nil {
+			fmt.Printf("error loading keychain item: %s\n", err)
+			continue
+		}
+		switch kci.Class {
+		case ClassCertificate:
+			certs = append(certs, kci)
+		case ClassIdentity:
+			identityCerts[kci.IdentityCertificateUUID] = true
+		}
+	}
+	for _, kci := range certs {
+		if cmd.Command.ManagedOnly {
+			managed, err := c.Device.isManagedProfile(kci.ProfileID)
+			if err != nil {
+				return nil, err
+			}
+			if !managed {
+				continue
+			}
+		}
+		resp.CertificateList = append(resp.CertificateList, certificateListItem{
+			CommonName: kci.Certificate.Subject.CommonName,
+			Data:       kci.Certificate.Raw,
+			IsIdentity: identityCerts[kci.UUID],
+		})
+	}
+	return resp, nil
+}
+
+type InstalledApplicationListCommand struct {
+	ConnectResponseCommand
+	Identifiers     []string `plist:",omitempty"`
+	ManagedAppsOnly bool     `plist:",omitempty"`
+}
+
+type InstalledApplicationList struct {
+	Command     InstalledApplicationListCommand
+	CommandUUID string
+}
+
+type InstalledApplicationListResponse struct {
+	ConnectRequest
+	InstalledApplicationList []installedApplication
+}
+
+type installedApplication struct {
+	Identifier   string
+	Name         string
+	Version      string
+	ShortVersion string
+	BundleSize   int64
+}
+
+func (c *MDMClient) handleInstalledApplicationList(respBytes []byte) (interface{}, error) {
+	cmd := &InstalledApplicationList{}
+	err := plist.Unmarshal(respBytes, cmd)
+	if err != nil {
+		return nil, err
+	}
+	resp := &InstalledApplicationListResponse{
+		ConnectRequest: ConnectRequest{
+			UDID:        c.Device.UDID,
+			Status:      "Acknowledged",
+			CommandUUID: cmd.CommandUUID,
+			RequestType: cmd.Command.RequestType,
+		},
+		InstalledApplicationList: []installedApplication{},
+	}
+	apps, err := c.Device.Apps()
+	if err != nil {
+		return nil, err
+	}
+	var ids map[string]bool
+	if len(cmd.Command.Identifiers) > 0 {
+		ids = make(map[string]bool)
+		for _, id := range cmd.Command.Identifiers {
+			ids[id] = true
+		}
+	}
+	for _, app := range apps {
+		if ids != nil && !ids[app.Identifier] {
+			continue
+		}
+		if cmd.Command.ManagedAppsOnly && !app.Managed {
+			continue
+		}
+		resp.InstalledApplicationList = append(resp.InstalledApplicationList, installedApplication{
+			Identifier:   app.Identifier,
+			Name:         app.Name,
+			Version:      app.Version,
+			ShortVersion: app.ShortVersion,
+			BundleSize:   app.BundleSize,
+		})
+	}
+	return resp, nil
+}
+
+type InstallApplicationCommand struct {
+	ConnectResponseCommand
+	ITunesStoreID   int    `plist:"iTunesStoreID,omitempty"`
+	Identifier      string `plist:",omitempty"`
+	ManifestURL     string `plist:",omitempty"`
+	ManagementFlags int    `plist:",omitempty"`
+	Configuration   map[string]interface{}
+}
+
+type InstallApplication struct {
+	Command     InstallApplicationCommand
+	CommandUUID string
+}
+
+type InstallApplicationResponse struct {
+	ConnectRequest
+	Identifier string
+	State      string
+}
+
+func (c *MDMClient) handleInstallApplication(ctx context.Context, respBytes []byte) (interface{}, error) {
+	cmd := &InstallApplication{}
+	err := plist.Unmarshal(respBytes, cmd)
+	if err != nil {
+		return nil, err
+	}
+	ma := &ManagedApp{
+		App:              App{Identifier: cmd.Command.Identifier},
+		ITunesStoreID:    cmd.Command.ITunesStoreID,
+		ManifestURL:      cmd.Command.ManifestURL,
+		ManagementFlags:  cmd.Command.ManagementFlags,
+		HasConfiguration: len(cmd.Command.Configuration) > 0,
+		State:            AppStateQueued,
+	}
+	if ma.ManifestURL != "" {
+		err = c.Device.fetchAppManifest(ctx, ma.ManifestURL, ma)
+		if err != nil {
+			fmt.Printf("error fetching app manifest: %s\n", err)
+			ma.State = AppStateFailed
+		}
+	}
+	if ma.Identifier == "" && ma.ITunesStoreID != 0 {
+		// we have no store to look up the bundle ID
+		ma.Identifier = fmt.Sprintf("id%d", ma.ITunesStoreID)
+	}
+	if ma.Identifier == "" {
+		return c.errorResponse(cmd.Command.RequestType, cmd.CommandUUID, ErrorChain{
+			ErrorCode:            12001,
+			ErrorDomain:          "MCMDMErrorDomain",
+			LocalizedDescription: "Invalid request: missing Identifier",
+		}), nil
+	}
+	if err = c.Device.saveManagedApp(ma); err != nil {
+		return nil, err
+	}
+	return &InstallApplicationResponse{
+		ConnectRequest: ConnectRequest{
+			UDID:        c.Device.UDID,
+			Status:      "Acknowledged",
+			CommandUUID: cmd.CommandUUID,
+			RequestType: cmd.Command.RequestType,
+		},
+		Identifier: ma.Identifier,
+		State:      ma.State,
+	}, nil
+}
+
+type RemoveApplicationCommand struct {
+	ConnectResponseCommand
+	Identifier string
+}
+
+type RemoveApplication struct {
+	Command     RemoveApplicationCommand
+	CommandUUID string
+}
+
+func (c *MDMClient) handleRemoveApplication(respBytes []byte) (interface{}, error) {
+	cmd := &RemoveApplication{}
+	err := plist.Unmarshal(respBytes, cmd)
+	if err != nil {
+		return nil, err
+	}
+	id := cmd.Command.Identifier
+	ma, err := c.Device.ManagedApp(id)
+	if err != nil {
+		return nil, err
+	}
+	app, err := c.Device.App(id)
+	if err != nil {
+		return nil, err
+	}
+	if ma == nil && (app == nil || !app.Managed) {
+		return c.errorResponse(cmd.Command.RequestType, cmd.CommandUUID, ErrorChain{
+			ErrorCode:            12029,
+			ErrorDomain:          "MCMDMErrorDomain",
+			LocalizedDescription: fmt.Sprintf("The app “%s” is not managed.", id),
+		}), nil
+	}
+	if err = c.Device.removeManagedApp(id); err != nil {
+		return nil, err
+	}
+	if err = c.Device.RemoveApp(id); err != nil {
+		return nil, err
+	}
+	return &ConnectRequest{
+		UDID:        c.Device.UDID,
+		Status:      "Acknowledged",
+		CommandUUID: cmd.CommandUUID,
+		RequestType: cmd.Command.RequestType,
+	}, nil
+}
+
+type ManagedApplicationListCommand struct {
+	ConnectResponseCommand
+	Identifiers []string `plist:",omitempty"`
+}
+
+type ManagedApplicationList struct {
+	Command     ManagedApplicationListCommand
+	CommandUUID string
+}
+
+type ManagedApplicationListResponse struct {
+	ConnectRequest
+	ManagedApplicationList map[string]managedApplication
+}
+
+type managedApplication struct {
+	Status           string
+	ManagementFlags  int
+	HasConfiguration bool
+	HasFeedback      bool
+	IsValidated      bool
+}
+
+func (c *MDMClient) handleManagedApplicationList(respBytes []byte) (interface{}, error) {
+	cmd := &ManagedApplicationList{}
+	err := plist.Unmarshal(respBytes, cmd)
+	if err != nil {
+		return nil, err
+	}
+	resp := &ManagedApplicationListResponse{
+		ConnectRequest: ConnectRequest{
+			UDID:        c.Device.UDID,
+			Status:      "Acknowledged",
+			CommandUUID: cmd.CommandUUID,
+			RequestType: cmd.Command.RequestType,
+		},
+		ManagedApplicationList: make(map[string]managedApplication),
+	}
+	mas, err := c.Device.ManagedApps()
+	if err != nil {
+		return nil, err
+	}
+	for _, ma := range mas {
+		resp.ManagedApplicationList[ma.Identifier] = managedApplication{
+			Status:           ma.State,
+			ManagementFlags:  ma.ManagementFlags,
+			HasConfiguration: ma.HasConfiguration,
+			IsValidated:      ma.State == AppStateManaged,
+		}
+	}
+	// managed apps the device was created with
+	apps, err := c.Device.Apps()
+	if err != nil {
+		return nil, err
+	}
+	for _, app := range apps {
+		if _, ok := resp.ManagedApplicationList[app.Identifier]; ok || !app.Managed {
+			continue
+		}
+		resp.ManagedApplicationList[app.Identifier] = managedApplication{
+			Status:      AppStateManaged,
+			IsValidated: true,
+		}
+	}
+	if len(cmd.Command.Identifiers) > 0 {
+		filtered := make(map[string]managedApplication)
+		for _, id := range cmd.Command.Identifiers {
+			if ma, ok := resp.ManagedApplicationList[id]; ok {
+				filtered[id] = ma
+			}
+		}
+		resp.ManagedApplicationList = filtered
+	}
+	return resp, nil
+}
+
+type SecurityInfoResponse struct {
+	ConnectRequest
+	SecurityInfo securityInfo
+}
+
+type securityInfo struct {
+	HardwareEncryptionCaps           int
+	PasscodePresent                  bool
+	PasscodeCompliant                bool
+	PasscodeCompliantWithProfiles    bool
+	FDEEnabled                       bool `plist:"FDE_Enabled"`
+	SystemIntegrityProtectionEnabled bool
+	FirewallSettings                 firewallSettings
+	SecureBoot                       secureBoot
+}
+
+type firewallSettings struct {
+	FirewallEnabled  bool
+	BlockAllIncoming bool
+	StealthMode      bool
+}
+
+type secureBoot struct {
+	SecureBootLevel string
+}
+
+func (c *MDMClient) handleSecurityInfo(reqType, commandUUID string) (interface{}, error) {
+	sec := &c.Device.Security
+	return &SecurityInfoResponse{
+		ConnectRequest: ConnectRequest{
+			UDID:        c.Device.UDID,
+			Status:      "Acknowledged",
+			CommandUUID: commandUUID,
+			RequestType: reqType,
+		},
+		SecurityInfo: securityInfo{
+			HardwareEncryptionCaps:           sec.HardwareEncryptionCaps,
+			PasscodePresent:                  sec.PasscodePresent,
+			PasscodeCompliant:                sec.PasscodeCompliant,
+			PasscodeCompliantWithProfiles:    sec.PasscodeCompliantWithProfiles,
+			FDEEnabled:                       sec.FileVaultEnabled,
+			SystemIntegrityProtectionEnabled: sec.SIPEnabled,
+			FirewallSettings: firewallSettings{
+				FirewallEnabled:  sec.FirewallEnabled,
+				BlockAllIncoming: sec.FirewallBlockAllIncoming,
+				StealthMode:      sec.FirewallStealthMode,
+			},
+			SecureBoot: secureBoot{SecureBootLevel: sec.SecureBootLevel},
+		},
+	}, nil
+}
+
+type DeclarativeManagementCommand struct {
+	ConnectResponseCommand
+	Data []byte `plist:",omitempty"`
+}
+
+type DeclarativeManagement struct {
+	Command     DeclarativeManagementCommand
+	CommandUUID string
+}
+
+func (c *MDMClient) handleDeclarativeManagement(respBytes []byte) (interface{}, error) {
+	cmd := &DeclarativeManagement{}
+	err := plist.Unmarshal(respBytes, cmd)
+	if err != nil {
+		return nil, err
+	}
+	// the server tokens are optional
+	var tokens *SyncTokens
+	if len(cmd.Command.Data) > 0 {
+		tr := &tokensResponse{}
+		if err = json.Unmarshal(cmd.Command.Data, tr); err != nil {
+			return nil, err
+		}
+		tokens = &tr.SyncTokens
+	}
+	c.ddmSync = true
+	c.ddmSyncTokens = tokens
+	return &ConnectRequest{
+		UDID:        c.Device.UDID,
+		Status:      "Acknowledged",
+		CommandUUID: cmd.CommandUUID,
+		RequestType: cmd.Command.RequestType,
+	}, nil
+}
+
+type AvailableOSUpdatesResponse struct {
+	ConnectRequest
+	AvailableOSUpdates []availableOSUpdate
+}
+
+type availableOSUpdate struct {
+	ProductKey         string
+	HumanReadableName  string
+	Version            string
+	Build              string
+	DownloadSize       int64
+	InstallSize        int64
+	IsCritical         bool
+	IsConfigDataUpdate bool
+	IsFirmwareUpdate   bool
+	IsMajorOSUpdate    bool
+	RestartRequired    bool
+	AllowsInstallLater bool
+}
+
+func (c *MDMClient) handleAvailableOSUpdates(reqType, commandUUID string) (interface{}, error) {
+	updates, err := c.Device.AvailableOSUpdates()
+	if err != nil {
+		return nil, err
+	}
+	resp := &AvailableOSUpdatesResponse{
+		ConnectRequest: ConnectRequest{
+			UDID:        c.Device.UDID,
+			Status:      "Acknowledged",
+			CommandUUID: commandUUID,
+			RequestType: reqType,
+		},
+		AvailableOSUpdates: []availableOSUpdate{},
+	}
+	for _, u := range updates {
+		resp.AvailableOSUpdates = append(resp.AvailableOSUpdates, availableOSUpdate{
+			ProductKey:         u.ProductKey,
+			HumanReadableName:  u.HumanReadableName,
+			Version:            u.Version,
+			Build:              u.Build,
+			DownloadSize:       u.DownloadSize,
+			InstallSize:        u.InstallSize,
+			IsCritical:         u.IsCritical,
+			IsMajorOSUpdate:    u.IsMajorOSUpdate,
+			RestartRequired:    true,
+			AllowsInstallLater: true,
+		})
+	}
+	return resp, nil
+}
+
+type ScheduleOSUpdateCommand struct {
+	ConnectResponseCommand
+	Updates []scheduleOSUpdateItem `plist:",omitempty"`
+}
+
+type scheduleOSUpdateItem struct {
+	ProductKey     string `plist:",omitempty"`
+	ProductVersion string `plist:",omitempty"`
+	InstallAction  string `plist:",omitempty"`
+}
+
+type ScheduleOSUpdate struct {
+	Command     ScheduleOSUpdateCommand
+	CommandUUID string
+}
+
+type ScheduleOSUpdateResponse struct {
+	ConnectRequest
+	UpdateResults []osUpdateResult
+}
+
+type osUpdateResult struct {
+	ProductKey    string
+	InstallAction string
+	Status        string
+}
+
+func (c *MDMClient) handleScheduleOSUpdate(respBytes []byte) (interface{}, error) {
+	cmd := &ScheduleOSUpdate{}
+	err := plist.Unmarshal(respBytes, cmd)
+	if err != nil {
+		return nil, err
+	}
+	available, err := c.Device.AvailableOSUpdates()
+	if err != nil {
+		return nil, err
+	}
+	items := cmd.Command.Updates
+	if len(items) == 0 && len(available) > 0 {
+		// no updates given: schedule the first available
+		items = []scheduleOSUpdateItem{{ProductKey: available[0].ProductKey}}
+	}
+	invalid := func(format string, a ...interface{}) *ConnectRequest {
+		return c.errorResponse(cmd.Command.RequestType, cmd.CommandUUID, ErrorChain{
+			ErrorCode:            12001,
+			ErrorDomain:          "MCMDMErrorDomain",
+			LocalizedDescription: "Invalid request: " + fmt.Sprintf(format, a...),
+		})
+	}
+	resp := &ScheduleOSUpdateResponse{
+		ConnectRequest: ConnectRequest{
+			UDID:        c.Device.UDID,
+			Status:      "Acknowledged",
+			CommandUUID: cmd.CommandUUID,
+			RequestType: cmd.Command.RequestType,
+		},
+		UpdateResults: []osUpdateResult{},
+	}
+	// like a real device only one update is in progress so a later
+	// update replaces an earlier one
+	for _, item := range items {
+		var update *OSUpdate
+		for i, u := range available {
+			if (item.ProductKey != "" && u.ProductKey == item.ProductKey) ||
+				(item.ProductKey == "" && item.ProductVersion != "" && u.Version == item.ProductVersion) {
+				update = &available[i]
+				break
+			}
+		}
+		if update == nil {
+			return invalid("update not available: %s%s", item.ProductKey, item.ProductVersion), nil
+		}
+		action := item.InstallAction
+		switch action {
+		case "":
+			action = OSUpdateActionDefault
+		case OSUpdateActionDefault, OSUpdateActionDownloadOnly, OSUpdateActionInstallASAP,
+			OSUpdateActionNotifyOnly, OSUpdateActionInstallLater, OSUpdateActionForceRestart:
+		default:
+			return invalid("unknown InstallAction: %s", action), nil
+		}
+		su, err := c.Device.scheduleOSUpdate(*update, action)
+		if err != nil {
+			return nil, err
+		}
+		resp.UpdateResults = append(resp.UpdateResults, osUpdateResult{
+			ProductKey:    su.ProductKey,
+			InstallAction: su.InstallAction,
+			Status:        su.Status,
+		})
+	}
+	return resp, nil
+}
+
+type OSUpdateStatusResponse struct {
+	ConnectRequest
+	OSUpdateStatus []osUpdateStatus
+}
+
+type osUpdateStatus struct {
+	ProductKey              string
+	IsDownloaded            bool
+	DownloadPercentComplete float64
+	Status                  string
+}
+
+func (c *MDMClient) handleOSUpdateStatus(reqType, commandUUID string) (interface{}, error) {
+	su, err := c.Device.ScheduledOSUpdate()
+	if err != nil {
+		return nil, err
+	}
+	resp := &OSUpdateStatusResponse{
+		ConnectRequest: ConnectRequest{
+			UDID:        c.Device.UDID,
+			Status:      "Acknowledged",
+			CommandUUID: commandUUID,
+			RequestType: reqType,
+		},
+		OSUpdateStatus: []osUpdateStatus{},
+	}
+	if su != nil {
+		resp.OSUpdateStatus = append(resp.OSUpdateStatus, osUpdateStatus{
+			ProductKey:              su.ProductKey,
+			IsDownloaded:            su.IsDownloaded,
+			DownloadPercentComplete: su.PercentComplete,
+			Status:                  su.Status,
+		})
+	}
+	return resp, nil
+}
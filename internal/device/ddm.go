@@ -0,0 +1,224 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Declaration types (as used in declaration endpoint paths)
+const (
+	DeclarationActivation    = "activation"
+	DeclarationAsset         = "asset"
+	DeclarationConfiguration = "configuration"
+	DeclarationManagement    = "management"
+)
+
+// DeclarativeManagementRequest is a DeclarativeManagement check-in
+// message. Endpoint selects the DDM resource (e.g. "tokens").
+type DeclarativeManagementRequest struct {
+	Data         []byte `plist:",omitempty"`
+	EnrollmentID string `plist:",omitempty"`
+	Endpoint     string
+	MessageType  string
+	UDID         string
+}
+
+func (r *DeclarativeManagementRequest) checkinMessageType() string {
+	// group declaration endpoints to limit the number of message types
+	endpoint := r.Endpoint
+	if i := strings.Index(endpoint, "/"); i >= 0 {
+		endpoint = endpoint[:i]
+	}
+	return r.MessageType + " " + endpoint
+}
+
+// SyncTokens are the DDM synchronization tokens of the server.
+type SyncTokens struct {
+	DeclarationsToken string
+	Timestamp         string `json:",omitempty"`
+}
+
+type tokensResponse struct {
+	SyncTokens SyncTokens
+}
+
+type declarationItem struct {
+	Identifier  string
+	ServerToken string
+}
+
+// declarationItems is the declaration manifest of the server.
+type declarationItems struct {
+	Declarations struct {
+		Activations    []declarationItem
+		Assets         []declarationItem
+		Configurations []declarationItem
+		Management     []declarationItem
+	}
+	DeclarationsToken string
+}
+
+// Declaration is a DDM declaration synchronized from the server.
+type Declaration struct {
+	Type        string
+	Identifier  string
+	ServerToken string
+	Payload     json.RawMessage
+}
+
+func (device *Device) declarationKey(declType, identifier string) string {
+	return fmt.Sprintf("%s_%s_%s", device.UDID, declType, identifier)
+}
+
+func (device *Device) saveDeclaration(declType string, raw []byte, identifier string) error {
+	return device.boltDB.Update(func(tx *bolt.Tx) error {
+		return BucketPutOrDelete(tx, "ddm_declarations", device.declarationKey(declType, identifier), raw)
+	})
+}
+
+// removeDeclarations removes all synchronized declarations, the record
+// of applied configurations, and the last reported status.
+func (device *Device) removeDeclarations() error {
+	return device.boltDB.Update(func(tx *bolt.Tx) error {
+		err := BucketPutOrDelete(tx, "ddm_status_reported", device.UDID, nil)
+		if err != nil {
+			return err
+		}
+		for _, b := range []struct{ bucket, prefix string }{
+			{"ddm_declarations", device.UDID + "_"},
+			{"ddm_applied_configurations", device.appliedConfigurationKey("")},
+		} {
+			for _, key := range BucketGetKeysWithPrefix(tx, b.bucket, b.prefix, false) {
+				if err := BucketPutOrDelete(tx, b.bucket, key, nil); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Declarations returns the synchronized declarations of declType (e.g.
+// DeclarationConfiguration) ordered by identifier.
+func (device *Device) Declarations(declType string) (decls []*Declaration, err error) {
+	err = device.boltDB.View(func(tx *bolt.Tx) error {
+		prefix := device.declarationKey(declType, "")
+		for _, id := range BucketGetKeysWithPrefix(tx, "ddm_declarations", prefix, true) {
+			decl := &Declaration{}
+			if err := json.Unmarshal(BucketGet(tx, "ddm_declarations", prefix+id), decl); err != nil {
+				return fmt.Errorf("declaration %s: %w", id, err)
+			}
+			decls = append(decls, decl)
+		}
+		return nil
+	})
+	return
+}
+
+// ddmRequest sends a DeclarativeManagement check-in message for
+// endpoint and decodes the JSON response into v.
+func (c *MDMClient) ddmRequest(ctx context.Context, endpoint string, data []byte, v interface{}) ([]byte, error) {
+	req := &DeclarativeManagementRequest{
+		Data:        data,
+		Endpoint:    endpoint,
+		MessageType: "DeclarativeManagement",
+		UDID:        c.Device.UDID,
+	}
+	respBytes, err := c.checkinRequestWithResponse(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if v != nil {
+		if err = json.Unmarshal(respBytes, v); err != nil {
+			return nil, fmt.Errorf("decoding %s response: %w", endpoint, err)
+		}
+	}
+	return respBytes, nil
+}
+
+// SyncDeclarations synchronizes the declarations of the device with
+// the MDM server. The server tokens are fetched if tokens is nil.
+// Nothing is synchronized if the declarations token has not changed.
+func (c *MDMClient) SyncDeclarations(ctx context.Context, tokens *SyncTokens) error {
+	if !c.enrolled() {
+		return errors.New("device not enrolled")
+	}
+	if tokens == nil {
+		tr := &tokensResponse{}
+		if _, err := c.ddmRequest(ctx, "tokens", nil, tr); err != nil {
+			return err
+		}
+		tokens = &tr.SyncTokens
+	}
+	if tokens.DeclarationsToken != "" && tokens.DeclarationsToken == c.Device.DeclarationsToken {
+		return nil
+	}
+
+	items := &declarationItems{}
+	if _, err := c.ddmRequest(ctx, "declaration-items", nil, items); err != nil {
+		return err
+	}
+	for _, t := range []struct {
+		declType string
+		items    []declarationItem
+	}{
+		{DeclarationActivation, items.Declarations.Activations},
+		{DeclarationAsset, items.Declarations.Assets},
+		{DeclarationConfiguration, items.Declarations.Configurations},
+		{DeclarationManagement, items.Declarations.Management},
+	} {
+		if err := c.syncDeclarations(ctx, t.declType, t.items); err != nil {
+			return err
+		}
+	}
+
+	c.Device.DeclarationsToken = items.DeclarationsToken
+	if err := c.Device.Save(); err != nil {
+		return err
+	}
+	return c.SendStatusReport(ctx, false)
+}
+
+// syncDeclarations downloads the changed declarations of declType and
+// removes those no longer in items.
+func (c *MDMClient) syncDeclarations(ctx context.Context, declType string, items []declarationItem) error {
+	decls, err := c.Device.Declarations(declType)
+	if err != nil {
+		return err
+	}
+	serverTokens := make(map[string]string)
+	for _, decl := range decls {
+		serverTokens[decl.Identifier] = decl.ServerToken
+	}
+	for _, item := range items {
+		serverToken, ok := serverTokens[item.Identifier]
+		delete(serverTokens, item.Identifier)
+		if ok && serverToken == item.ServerToken {
+			continue
+		}
+		decl := &Declaration{}
+		endpoint := fmt.Sprintf("declaration/%s/%s", declType, item.Identifier)
+		raw, err := c.ddmRequest(ctx, endpoint, nil, decl)
+		if err != nil {
+			return err
+		}
+		if decl.Identifier != item.Identifier {
+			return fmt.Errorf("declaration identifier mismatch: %s", decl.Identifier)
+		}
+		if err = c.Device.saveDeclaration(declType, raw, item.Identifier); err != nil {
+			return err
+		}
+	}
+	// remaining declarations were removed on the server
+	for id := range serverTokens {
+		if err = c.Device.saveDeclaration(declType, nil, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/groob/plist"
+	"github.com/jessepeterson/cfgprofiles"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Configuration declaration types with effects on the device
+const (
+	ConfigurationLegacyProfile    = "com.apple.configuration.legacy.profile"
+	ConfigurationPasscodeSettings = "com.apple.configuration.passcode.settings"
+)
+
+// appliedConfiguration records a configuration applied to the device.
+type appliedConfiguration struct {
+	ServerToken string
+	// identifier of the profile installed by a legacy profile
+	// configuration
+	ProfileIdentifier string `json:",omitempty"`
+	Error             string `json:",omitempty"`
+}
+
+func (device *Device) appliedConfigurationKey(identifier string) string {
+	return fmt.Sprintf("%s_%s", device.UDID, identifier)
+}
+
+func (device *Device) appliedConfigurations() (applied map[string]*appliedConfiguration, err error) {
+	applied = make(map[string]*appliedConfiguration)
+	err = device.boltDB.View(func(tx *bolt.Tx) error {
+		prefix := device.appliedConfigurationKey("")
+		for _, id := range BucketGetKeysWithPrefix(tx, "ddm_applied_configurations", prefix, true) {
+			ac := &appliedConfiguration{}
+			if err := json.Unmarshal(BucketGet(tx, "ddm_applied_configurations", prefix+id), ac); err != nil {
+				return fmt.Errorf("applied configuration %s: %w", id, err)
+			}
+			applied[id] = ac
+		}
+		return nil
+	})
+	return
+}
+
+func (device *Device) saveAppliedConfiguration(identifier string, ac *appliedConfiguration) error {
+	var acb []byte
+	if ac != nil {
+		var err error
+		if acb, err = json.Marshal(ac); err != nil {
+			return err
+		}
+	}
+	return device.boltDB.Update(func(tx *bolt.Tx) error {
+		return BucketPutOrDelete(tx, "ddm_applied_configurations", device.appliedConfigurationKey(identifier), acb)
+	})
+}
+
+// ddmState is the outcome of processing the declarations of a device.
+type ddmState struct {
+	declarations     map[string][]declarationStatus
+	passcodeRequired bool
+}
+
+type activationPayload struct {
+	StandardConfigurations []string
+	Predicate              string
+}
+
+type legacyProfilePayload struct {
+	ProfileURL string
+}
+
+type passcodeSettingsPayload struct {
+	RequirePasscode bool
+	MinimumLength   int
+}
+
+// processDeclarations validates the declarations of the device,
+// evaluates activation predicates against status, and applies (or
+// un-applies) configurations as they become (in)active.
+func (c *MDMClient) processDeclarations(ctx context.Context, status map[string]interface{}) (*ddmState, error) {
+	state := &ddmState{declarations: make(map[string][]declarationStatus)}
+	activated := make(map[string]bool)
+	applied, err := c.Device.appliedConfigurations()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range []struct{ declType, key string }{
+		// activations first to know which configurations are active
+		{DeclarationActivation, "activations"},
+		{DeclarationAsset, "assets"},
+		{DeclarationConfiguration, "configurations"},
+		{DeclarationManagement, "management"},
+	} {
+		decls, err := c.Device.Declarations(t.declType)
+		if err != nil {
+			return nil, err
+		}
+		statuses := []declarationStatus{}
+		for _, decl := range decls {
+			ds := declarationStatus{
+				Identifier:  decl.Identifier,
+				ServerToken: decl.ServerToken,
+				Valid:       "valid",
+			}
+			if reason := validateDeclaration(t.declType, decl); reason != nil {
+				ds.Valid = "invalid"
+				ds.Reasons = []statusReason{*reason}
+				statuses = append(statuses, ds)
+				continue
+			}
+			switch t.declType {
+			case DeclarationActivation:
+				ap := &activationPayload{}
+				if err := json.Unmarshal(decl.Payload, ap); err != nil {
+					ds.Valid = "invalid"
+					ds.Reasons = []statusReason{{
+						Code:        "Error.InvalidPayload",
+						Description: fmt.Sprintf("Invalid activation payload: %s", err),
+					}}
+					break
+				}
+				ds.Active = true
+				if ap.Predicate != "" {
+					ds.Active, err = evalPredicate(ap.Predicate, status)
+					if err != nil {
+						ds.Active = false
+						ds.Valid = "invalid"
+						ds.Reasons = []statusReason{{
+							Code:        "Error.InvalidPredicate",
+							Description: fmt.Sprintf("Invalid predicate: %s", err),
+						}}
+					}
+				}
+				if ds.Active {
+					for _, id := range ap.StandardConfigurations {
+						activated[id] = true
+					}
+				}
+			case DeclarationConfiguration:
+				if !activated[decl.Identifier] {
+					break
+				}
+				err = c.applyConfiguration(ctx, decl, applied[decl.Identifier])
+				delete(applied, decl.Identifier)
+				if err != nil {
+					ds.Valid = "invalid"
+					ds.Reasons = []statusReason{{
+						Code:        "Error.ConfigurationCannotBeApplied",
+						Description: err.Error(),
+					}}
+					break
+				}
+				ds.Active = true
+				if decl.Type == ConfigurationPasscodeSettings {
+					pp := &passcodeSettingsPayload{}
+					if json.Unmarshal(decl.Payload, pp) == nil && (pp.RequirePasscode || pp.MinimumLength > 0) {
+						state.passcodeRequired = true
+					}
+				}
+			default:
+				ds.Active = true
+			}
+			statuses = append(statuses, ds)
+		}
+		state.declarations[t.key] = statuses
+	}
+
+	// the remaining configurations were removed or deactivated
+	for id, ac := range applied {
+		if err = c.unapplyConfiguration(ctx, id, ac); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// applyConfiguration applies the configuration decl unless it was
+// already applied (as recorded in ac). Failures are recorded and
+// returned until the configuration changes.
+func (c *MDMClient) applyConfiguration(ctx context.Context, decl *Declaration, ac *appliedConfiguration) error {
+	if ac != nil && ac.ServerToken == decl.ServerToken {
+		if ac.Error != "" {
+			return errors.New(ac.Error)
+		}
+		return nil
+	}
+	newAC := &appliedConfiguration{ServerToken: decl.ServerToken}
+	var err error
+	switch decl.Type {
+	case ConfigurationLegacyProfile:
+		newAC.ProfileIdentifier, err = c.installLegacyProfile(ctx, decl)
+		if ac != nil && ac.ProfileIdentifier != "" && ac.ProfileIdentifier != newAC.ProfileIdentifier {
+			if rmErr := c.Device.RemoveProfile(ctx, ac.ProfileIdentifier); rmErr != nil {
+				fmt.Printf("error removing legacy profile: %s\n", rmErr)
+			}
+		}
+	}
+	if err != nil {
+		newAC.Error = err.Error()
+	}
+	if saveErr := c.Device.saveAppliedConfiguration(decl.Identifier, newAC); saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+// installLegacyProfile downloads and installs the profile of a legacy
+// profile configuration and returns its identifier.
+func (c *MDMClient) installLegacyProfile(ctx context.Context, decl *Declaration) (string, error) {
+	pl := &legacyProfilePayload{}
+	if err := json.Unmarshal(decl.Payload, pl); err != nil {
+		return "", err
+	}
+	if pl.ProfileURL == "" {
+		return "", errors.New("missing ProfileURL")
+	}
+	pb, err := c.Device.httpGet(ctx, "profile", pl.ProfileURL)
+	if err != nil {
+		return "", fmt.Errorf("downloading profile: %w", err)
+	}
+	p := &cfgprofiles.Profile{}
+	if err = plist.Unmarshal(pb, p); err != nil {
+		return "", fmt.Errorf("parsing profile: %w", err)
+	}
+	if len(p.MDMPayloads()) > 0 {
+		// e.g. the enrollment profile
+		return "", errors.New("profile contains an MDM payload")
+	}
+	if err = c.Device.installProfileFromDDM(ctx, pb); err != nil {
+		return "", fmt.Errorf("installing profile: %w", err)
+	}
+	return p.PayloadIdentifier, nil
+}
+
+// unapplyConfiguration removes the effects of a configuration that is
+// no longer active.
+func (c *MDMClient) unapplyConfiguration(ctx context.Context, identifier string, ac *appliedConfiguration) error {
+	if ac.ProfileIdentifier != "" {
+		if err := c.Device.RemoveProfile(ctx, ac.ProfileIdentifier); err != nil {
+			fmt.Printf("error removing legacy profile: %s\n", err)
+		}
+	}
+	return c.Device.saveAppliedConfiguration(identifier, nil)
+}
@@ -0,0 +1,58 @@
+package device
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+const testEnrollmentProfile = `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0"><dict>
+<key>PayloadContent</key><array><dict>
+<key>PayloadType</key><string>com.apple.mdm</string>
+<key>PayloadIdentifier</key><string>com.example.enroll.mdm</string>
+<key>PayloadUUID</key><string>MDM-UUID</string>
+<key>PayloadVersion</key><integer>1</integer>
+<key>IdentityCertificateUUID</key><string>SCEP-UUID</string>
+<key>Topic</key><string>com.apple.mgmt.test</string>
+<key>ServerURL</key><string>https://mdm.example.com/mdm</string>
+</dict></array>
+<key>PayloadIdentifier</key><string>com.example.enroll</string>
+<key>PayloadType</key><string>Configuration</string>
+<key>PayloadUUID</key><string>ENROLL-UUID</string>
+<key>PayloadVersion</key><integer>1</integer>
+</dict></plist>`
+
+func TestInstallLegacyProfileWithMDMPayload(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testEnrollmentProfile))
+	}))
+	defer srv.Close()
+
+	// the enrollment profile delivered by a legacy profile configuration
+	dev := New("test", openTestDB(t))
+	dev.MDMProfileIdentifier = "com.example.enroll"
+	err := dev.SystemProfileStore().persistProfile([]byte(testEnrollmentProfile), dev.MDMProfileIdentifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &MDMClient{Device: dev}
+	decl := &Declaration{
+		Identifier:  "com.example.legacy",
+		ServerToken: "1",
+		Type:        ConfigurationLegacyProfile,
+		Payload:     []byte(`{"ProfileURL": "` + srv.URL + `"}`),
+	}
+	if err = c.applyConfiguration(context.Background(), decl, nil); err == nil {
+		t.Fatal("expected error applying legacy profile with an MDM payload")
+	}
+	ids, err := dev.SystemProfileStore().ListUUIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := ids, []string{dev.MDMProfileIdentifier}; !reflect.DeepEqual(have, want) {
+		t.Errorf("installed profiles: have %v, want %v", have, want)
+	}
+}
@@ -0,0 +1,245 @@
+package device
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// declarationStatus is the status of a declaration in the
+// management.declarations status item.
+type declarationStatus struct {
+	Identifier  string         `json:"identifier"`
+	ServerToken string         `json:"server-token"`
+	Active      bool           `json:"active"`
+	Valid       string         `json:"valid"`
+	Reasons     []statusReason `json:"reasons,omitempty"`
+}
+
+type statusReason struct {
+	Code        string `json:"code"`
+	Description string `json:"description,omitempty"`
+}
+
+// statusReport is the Data of a DeclarativeManagement status check-in.
+type statusReport struct {
+	StatusItems map[string]interface{}
+	Errors      []interface{}
+	FullReport  bool
+}
+
+// declaration type prefixes of each declaration type
+var declarationTypePrefixes = map[string]string{
+	DeclarationActivation:    "com.apple.activation.",
+	DeclarationAsset:         "com.apple.asset.",
+	DeclarationConfiguration: "com.apple.configuration.",
+	DeclarationManagement:    "com.apple.management.",
+}
+
+// validateDeclaration returns the reason decl of declType is invalid or
+// nil if it is valid.
+func validateDeclaration(declType string, decl *Declaration) *statusReason {
+	if !strings.HasPrefix(decl.Type, declarationTypePrefixes[declType]) {
+		return &statusReason{
+			Code:        "Error.UnknownDeclarationType",
+			Description: "Unknown declaration type: " + decl.Type,
+		}
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(decl.Payload, &payload); err != nil || payload == nil {
+		return &statusReason{
+			Code:        "Error.InvalidPayload",
+			Description: "Invalid declaration payload",
+		}
+	}
+	return nil
+}
+
+// StatusItems returns the configured DDM status items of the device.
+func (device *Device) StatusItems() (items map[string]interface{}, err error) {
+	err = device.boltDB.View(func(tx *bolt.Tx) error {
+		b := BucketGet(tx, "ddm_status_items", device.UDID)
+		if len(b) == 0 {
+			return nil
+		}
+		return json.Unmarshal(b, &items)
+	})
+	return
+}
+
+// SetStatusItems configures DDM status items (e.g.
+// "softwareupdate.install-state") of the device. They are reported in
+// addition to, or instead of, the computed status items. Items with nil
+// values are removed.
+func (device *Device) SetStatusItems(set map[string]interface{}) error {
+	items, err := device.StatusItems()
+	if err != nil {
+		return err
+	}
+	if items == nil {
+		items = make(map[string]interface{})
+	}
+	for k, v := range set {
+		if v == nil {
+			delete(items, k)
+		} else {
+			items[k] = v
+		}
+	}
+	b, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		b = nil
+	}
+	return device.boltDB.Update(func(tx *bolt.Tx) error {
+		return BucketPutOrDelete(tx, "ddm_status_items", device.UDID, b)
+	})
+}
+
+// baseStatusItems returns the status items of the device, by their
+// dotted key, that do not depend on the declarations or OS updates.
+func (device *Device) baseStatusItems() map[string]interface{} {
+	family, osFamily := device.ModelFamily()
+	return map[string]interface{}{
+		"device.identifier.serial-number":       device.Serial,
+		"device.identifier.udid":                device.UDID,
+		"device.model.family":                   family,
+		"device.model.identifier":               device.ProductName,
+		"device.model.marketing-name":           device.ModelName(),
+		"device.operating-system.build-version": device.BuildVersion,
+		"device.operating-system.family":        osFamily,
+		"device.operating-system.version":       device.OSVersion,
+		"passcode.is-compliant":                 device.PasscodeCompliant,
+		"passcode.is-present":                   device.PasscodePresent,
+	}
+}
+
+// statusItems processes the declarations and returns all status items
+// of the device by their dotted key. Configured status items take
+// precedence over the computed ones. Activation predicates are
+// evaluated against the base and configured status items.
+func (c *MDMClient) statusItems(ctx context.Context) (map[string]interface{}, error) {
+	items := c.Device.baseStatusItems()
+	su, err := c.Device.ScheduledOSUpdate()
+	if err != nil {
+		return nil, err
+	}
+	items["softwareupdate.install-state"] = su.softwareUpdateInstallState()
+	if su != nil {
+		items["softwareupdate.pending-version"] = map[string]string{
+			"build-version": su.Build,
+			"os-version":    su.Version,
+		}
+	}
+	configured, err := c.Device.StatusItems()
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range configured {
+		items[k] = v
+	}
+	state, err := c.processDeclarations(ctx, items)
+	if err != nil {
+		return nil, err
+	}
+	items["management.declarations"] = state.declarations
+	if state.passcodeRequired && !c.Device.PasscodePresent {
+		items["passcode.is-compliant"] = false
+	}
+	for k, v := range configured {
+		items[k] = v
+	}
+	return items, nil
+}
+
+// nestStatusItems converts dotted status item keys into nested
+// dictionaries.
+func nestStatusItems(items map[string]interface{}) map[string]interface{} {
+	nested := make(map[string]interface{})
+	for k, v := range items {
+		parts := strings.Split(k, ".")
+		m := nested
+		for _, p := range parts[:len(parts)-1] {
+			sub, ok := m[p].(map[string]interface{})
+			if !ok {
+				sub = make(map[string]interface{})
+				m[p] = sub
+			}
+			m = sub
+		}
+		m[parts[len(parts)-1]] = v
+	}
+	return nested
+}
+
+// SendStatusReport sends a DDM status report to the MDM server. Unless
+// full is set only the status items changed since the last report are
+// sent, if any. The first report is always a full report.
+func (c *MDMClient) SendStatusReport(ctx context.Context, full bool) error {
+	if !c.enrolled() {
+		return errors.New("device not enrolled")
+	}
+	items, err := c.statusItems(ctx)
+	if err != nil {
+		return err
+	}
+	encoded := make(map[string]json.RawMessage)
+	for k, v := range items {
+		if encoded[k], err = json.Marshal(v); err != nil {
+			return err
+		}
+	}
+
+	var reported map[string]json.RawMessage
+	err = c.Device.boltDB.View(func(tx *bolt.Tx) error {
+		b := BucketGet(tx, "ddm_status_reported", c.Device.UDID)
+		if len(b) == 0 {
+			return nil
+		}
+		return json.Unmarshal(b, &reported)
+	})
+	if err != nil {
+		return err
+	}
+
+	report := &statusReport{
+		StatusItems: items,
+		Errors:      []interface{}{},
+		FullReport:  full || reported == nil,
+	}
+	if !report.FullReport {
+		changed := make(map[string]interface{})
+		for k, v := range encoded {
+			if !bytes.Equal(v, reported[k]) {
+				changed[k] = v
+			}
+		}
+		if len(changed) == 0 {
+			return nil
+		}
+		report.StatusItems = changed
+	}
+	report.StatusItems = nestStatusItems(report.StatusItems)
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	if _, err = c.ddmRequest(ctx, "status", data, nil); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(encoded)
+	if err != nil {
+		return err
+	}
+	return c.Device.boltDB.Update(func(tx *bolt.Tx) error {
+		return BucketPutOrDelete(tx, "ddm_status_reported", c.Device.UDID, b)
+	})
+}
@@ -0,0 +1,58 @@
+package device
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNestStatusItems(t *testing.T) {
+	for _, test := range []struct {
+		items map[string]interface{}
+		want  map[string]interface{}
+	}{
+		{
+			items: map[string]interface{}{},
+			want:  map[string]interface{}{},
+		},
+		{
+			items: map[string]interface{}{"model": "Mac"},
+			want:  map[string]interface{}{"model": "Mac"},
+		},
+		{
+			items: map[string]interface{}{
+				"device.model.family":             "Mac",
+				"device.model.identifier":         "Mac14,2",
+				"device.operating-system.version": "15.5",
+				"passcode.is-present":             true,
+			},
+			want: map[string]interface{}{
+				"device": map[string]interface{}{
+					"model": map[string]interface{}{
+						"family":     "Mac",
+						"identifier": "Mac14,2",
+					},
+					"operating-system": map[string]interface{}{
+						"version": "15.5",
+					},
+				},
+				"passcode": map[string]interface{}{
+					"is-present": true,
+				},
+			},
+		},
+		{
+			items: map[string]interface{}{
+				"management.declarations": map[string]interface{}{"activations": []interface{}{}},
+			},
+			want: map[string]interface{}{
+				"management": map[string]interface{}{
+					"declarations": map[string]interface{}{"activations": []interface{}{}},
+				},
+			},
+		},
+	} {
+		if have := nestStatusItems(test.items); !reflect.DeepEqual(have, test.want) {
+			t.Errorf("nest %v: have %v, want %v", test.items, have, test.want)
+		}
+	}
+}
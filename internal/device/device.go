@@ -1,10 +1,14 @@
 package device
 
 import (
+	"context"
+	"io"
 	"math/rand"
+	"net/http"
 	"strings"
 
 	"github.com/google/uuid"
+	"github.com/jessepeterson/mdmb/protocol"
 	bolt "go.etcd.io/bbolt"
 )
 
@@ -17,19 +21,100 @@ type Device struct {
 	MDMIdentityKeychainUUID string
 	MDMProfileIdentifier    string
 
+	// APNs push values last sent to the MDM server in a TokenUpdate
+	PushMagic string
+	PushToken []byte
+
+	// DDM declarations token of the last synchronization
+	DeclarationsToken string
+
 	BuildVersion string
 	OSVersion    string
 	ProductName  string
 
+	Hardware
+	Security
+
 	boltDB *bolt.DB
 
+	// HTTP client for MDM and SCEP requests; nil for the default
+	httpClient protocol.Doer
+	// HTTP client for MDM requests presenting the device identity as
+	// TLS client certificate; created from httpClient if nil
+	clientCertHTTPClient protocol.Doer
+	// fleet-wide MDM command response policies
+	responsePolicies []ResponsePolicy
+
 	sysKeychain     *Keychain
 	sysProfileStore *ProfileStore
 	mdmClient       *MDMClient
 }
 
+// Option configures a device.
+type Option func(*Device)
+
+// WithHTTPClient configures the HTTP client used for the MDM and SCEP
+// requests of the device.
+func WithHTTPClient(doer protocol.Doer) Option {
+	return func(d *Device) {
+		d.httpClient = doer
+	}
+}
+
+// WithClientCertHTTPClient configures the HTTP client used for MDM
+// requests when the device identity is presented as the TLS client
+// certificate. It must be from protocol.NewClientCertificateClient and
+// should be reused for the device to reuse connections.
+func WithClientCertHTTPClient(doer protocol.Doer) Option {
+	return func(d *Device) {
+		d.clientCertHTTPClient = doer
+	}
+}
+
+// clientCertClient returns the HTTP client for MDM requests presenting
+// the device identity as TLS client certificate or nil if the HTTP
+// client of the device doesn't support it.
+func (device *Device) clientCertClient() protocol.Doer {
+	if device.clientCertHTTPClient != nil {
+		return device.clientCertHTTPClient
+	}
+	client, ok := device.httpClient.(*http.Client)
+	if !ok && device.httpClient != nil {
+		return nil
+	}
+	cc, err := protocol.NewClientCertificateClient(client)
+	if err != nil {
+		return nil
+	}
+	device.clientCertHTTPClient = cc
+	return cc
+}
+
+// httpGet fetches url with the HTTP client of the device. request
+// names the request in errors.
+func (device *Device) httpGet(ctx context.Context, request, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	var res *http.Response
+	if device.httpClient != nil {
+		res, err = device.httpClient.Do(req)
+	} else {
+		res, err = http.DefaultClient.Do(req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, &HTTPStatusError{Request: request, StatusCode: res.StatusCode, Status: res.Status}
+	}
+	return io.ReadAll(res.Body)
+}
+
 // New creates a new device with a random serial number and UDID
-func New(name string, db *bolt.DB) *Device {
+func New(name string, db *bolt.DB, opts ...Option) *Device {
 	device := &Device{
 		ComputerName: name,
 		Serial:       randSerial(),
@@ -37,11 +122,16 @@ func New(name string, db *bolt.DB) *Device {
 		BuildVersion: "24E263",
 		OSVersion:    "15.4",
 		ProductName:  "Mac16,10",
+		Hardware:     randHardware(),
+		Security:     defaultSecurity(),
 		boltDB:       db,
 	}
 	if name == "" {
 		device.ComputerName = device.Serial + "'s Computer"
 	}
+	for _, opt := range opts {
+		opt(device)
+	}
 	return device
 }
 
@@ -0,0 +1,97 @@
+package device
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+)
+
+// Hardware are the (randomly generated) hardware attributes of a device
+// reported in DeviceInformation responses.
+type Hardware struct {
+	Model                   string // e.g. "MU9D3"
+	DeviceCapacity          float64
+	AvailableDeviceCapacity float64
+	BatteryLevel            float64
+	WiFiMAC                 string
+	BluetoothMAC            string
+	EthernetMACs            []string
+	IsSupervised            bool
+	IsActivationLockEnabled bool
+}
+
+// device capacities in GB as reported by devices
+var deviceCapacities = []float64{245.11, 494.38, 994.66, 1995.22}
+
+// Apple OUI of generated MAC addresses
+const macOUI = "f0:18:98"
+
+func randMAC() string {
+	return fmt.Sprintf("%s:%02x:%02x:%02x", macOUI, rand.Intn(256), rand.Intn(256), rand.Intn(256))
+}
+
+// round2 rounds f to two decimal places.
+func round2(f float64) float64 {
+	return math.Round(f*100) / 100
+}
+
+func randHardware() Hardware {
+	b := make([]byte, 4)
+	for i := range b {
+		b[i] = serialLetters[rand.Intn(len(serialLetters))]
+	}
+	capacity := deviceCapacities[rand.Intn(len(deviceCapacities))]
+	return Hardware{
+		Model:                   "M" + string(b),
+		DeviceCapacity:          capacity,
+		AvailableDeviceCapacity: round2(capacity * (0.1 + 0.8*rand.Float64())),
+		BatteryLevel:            round2(0.2 + 0.8*rand.Float64()),
+		WiFiMAC:                 randMAC(),
+		BluetoothMAC:            randMAC(),
+		EthernetMACs:            []string{randMAC()},
+	}
+}
+
+// model names of some product names
+var modelNames = map[string]string{
+	"Mac14,2":  "MacBook Air",
+	"Mac14,3":  "Mac mini",
+	"Mac15,3":  "MacBook Pro",
+	"Mac15,12": "MacBook Air",
+	"Mac16,1":  "MacBook Pro",
+	"Mac16,10": "Mac mini",
+	"Mac16,11": "Mac mini",
+	"Mac16,2":  "iMac",
+}
+
+// product families of product name prefixes
+var productFamilies = []struct{ prefix, family, osFamily string }{
+	{"iPhone", "iPhone", "iOS"},
+	{"iPad", "iPad", "iPadOS"},
+	{"iPod", "iPod touch", "iOS"},
+	{"AppleTV", "Apple TV", "tvOS"},
+	{"Watch", "Apple Watch", "watchOS"},
+	{"RealityDevice", "Apple Vision Pro", "visionOS"},
+}
+
+// ModelFamily returns the model family (e.g. "Mac" or "iPhone") and OS
+// family (e.g. "macOS") of the device product name.
+func (device *Device) ModelFamily() (family, osFamily string) {
+	for _, p := range productFamilies {
+		if strings.HasPrefix(device.ProductName, p.prefix) {
+			return p.family, p.osFamily
+		}
+	}
+	return "Mac", "macOS"
+}
+
+// ModelName returns the model name (e.g. "Mac mini") of the device
+// product name.
+func (device *Device) ModelName() string {
+	if name, ok := modelNames[device.ProductName]; ok {
+		return name
+	}
+	family, _ := device.ModelFamily()
+	return family
+}
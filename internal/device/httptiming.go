@@ -0,0 +1,109 @@
+package device
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// HTTPTiming is the breakdown of the elapsed time of a single HTTP
+// request. DNS, Connect, and TLS are zero when a connection was reused.
+type HTTPTiming struct {
+	DNS     time.Duration // DNS lookup
+	Connect time.Duration // TCP connect
+	TLS     time.Duration // TLS handshake
+	// TTFB is the time to the first response byte after the request
+	// was written, i.e. server (and any load balancer) processing.
+	TTFB time.Duration
+	// Transfer is the time from the first response byte until the
+	// response body was read.
+	Transfer time.Duration
+	Total    time.Duration
+
+	Reused bool // connection was reused
+}
+
+// httpTimer collects the httptrace events of a request.
+type httpTimer struct {
+	mu           sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+	reused       bool
+}
+
+func (t *httpTimer) set(tm *time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	*tm = time.Now()
+}
+
+// setFirst is like set but only for the first event of its kind (e.g.
+// for multiple connection attempts).
+func (t *httpTimer) setFirst(tm *time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if tm.IsZero() {
+		*tm = time.Now()
+	}
+}
+
+// withHTTPTimer returns a new context based on ctx that times the HTTP
+// request made with it. The timer is nil if ctx is not traced.
+func withHTTPTimer(ctx context.Context) (context.Context, *httpTimer) {
+	if contextTrace(ctx) == nil {
+		return ctx, nil
+	}
+	t := &httpTimer{start: time.Now()}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { t.setFirst(&t.dnsStart) },
+		DNSDone:           func(httptrace.DNSDoneInfo) { t.set(&t.dnsDone) },
+		ConnectStart:      func(_, _ string) { t.setFirst(&t.connectStart) },
+		ConnectDone:       func(_, _ string, _ error) { t.set(&t.connectDone) },
+		TLSHandshakeStart: func() { t.set(&t.tlsStart) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { t.set(&t.tlsDone) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.reused = info.Reused
+			t.mu.Unlock()
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { t.set(&t.wroteRequest) },
+		GotFirstResponseByte: func() { t.set(&t.firstByte) },
+	}), t
+}
+
+func between(start, end time.Time) time.Duration {
+	if start.IsZero() || end.IsZero() {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// done completes timing (i.e. the response body has been read) and
+// calls the trace hook in ctx for request.
+func (t *httpTimer) done(ctx context.Context, request string) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	t.mu.Lock()
+	timing := HTTPTiming{
+		DNS:      between(t.dnsStart, t.dnsDone),
+		Connect:  between(t.connectStart, t.connectDone),
+		TLS:      between(t.tlsStart, t.tlsDone),
+		TTFB:     between(t.wroteRequest, t.firstByte),
+		Transfer: between(t.firstByte, now),
+		Total:    now.Sub(t.start),
+		Reused:   t.reused,
+	}
+	t.mu.Unlock()
+	contextTrace(ctx).httpTiming(request, timing)
+}
@@ -23,6 +23,10 @@ type KeychainItem struct {
 	Class int
 	Item  []byte
 
+	// ProfileID is the identifier of the profile that installed the
+	// item, if any
+	ProfileID string
+
 	// ClassIdentity
 	IdentityCertificateUUID string
 	IdentityKeyUUID         string
@@ -22,6 +22,10 @@ func (kci *KeychainItem) Save() error {
 		if err != nil {
 			return err
 		}
+		err = BucketPutOrDeleteString(tx, "keychain_item_profile_id", kci.boltKey(), kci.ProfileID)
+		if err != nil {
+			return err
+		}
 		return BucketPutOrDeleteInt(tx, "keychain_item_class", kci.boltKey(), kci.Class)
 	})
 }
@@ -32,6 +36,10 @@ func (kci *KeychainItem) Delete() error {
 		if err != nil {
 			return err
 		}
+		err = BucketPutOrDeleteString(tx, "keychain_item_profile_id", kci.boltKey(), "")
+		if err != nil {
+			return err
+		}
 		return BucketPutOrDeleteInt(tx, "keychain_item_class", kci.boltKey(), 0)
 	})
 }
@@ -51,6 +59,7 @@ func LoadKeychainItem(kc *Keychain, uuid string) (kci *KeychainItem, err error)
 		if kci.Class == 0 {
 			return errors.New("invalid keychain item class 0")
 		}
+		kci.ProfileID = BucketGetString(tx, "keychain_item_profile_id", kci.boltKey())
 		return nil
 	})
 	if err != nil {
@@ -59,3 +68,13 @@ func LoadKeychainItem(kc *Keychain, uuid string) (kci *KeychainItem, err error)
 	err = kci.decode()
 	return
 }
+
+// ListItemUUIDs returns the UUIDs of all items in a keychain.
+func (kc *Keychain) ListItemUUIDs() (uuids []string, err error) {
+	prefix := strings.Join([]string{kc.ID, kc.Type, ""}, "_")
+	err = kc.DB.View(func(tx *bolt.Tx) error {
+		uuids = BucketGetKeysWithPrefix(tx, "keychain_items_item", prefix, true)
+		return nil
+	})
+	return
+}
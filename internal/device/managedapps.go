@@ -0,0 +1,149 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/groob/plist"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Managed app install states (as reported in ManagedApplicationList)
+const (
+	AppStateQueued     = "Queued"
+	AppStatePrompting  = "Prompting"
+	AppStateInstalling = "Installing"
+	AppStateManaged    = "Managed"
+	AppStateFailed     = "Failed"
+)
+
+// nextAppState is the state a managed app install moves to on the
+// next MDM connect.
+var nextAppState = map[string]string{
+	AppStateQueued:     AppStatePrompting,
+	AppStatePrompting:  AppStateInstalling,
+	AppStateInstalling: AppStateManaged,
+}
+
+// ManagedApp is an app install requested by the MDM server.
+type ManagedApp struct {
+	App
+
+	ITunesStoreID    int    `json:"itunes_store_id,omitempty"`
+	ManifestURL      string `json:"manifest_url,omitempty"`
+	ManagementFlags  int    `json:"management_flags,omitempty"`
+	HasConfiguration bool   `json:"has_configuration,omitempty"`
+	State            string `json:"state"`
+}
+
+func (device *Device) saveManagedApp(ma *ManagedApp) error {
+	mab, err := json.Marshal(ma)
+	if err != nil {
+		return err
+	}
+	return device.boltDB.Update(func(tx *bolt.Tx) error {
+		return BucketPutOrDelete(tx, "device_managed_apps", device.appKey(ma.Identifier), mab)
+	})
+}
+
+func (device *Device) removeManagedApp(identifier string) error {
+	return device.boltDB.Update(func(tx *bolt.Tx) error {
+		return BucketPutOrDelete(tx, "device_managed_apps", device.appKey(identifier), nil)
+	})
+}
+
+// ManagedApp returns the app install requested by the MDM server with
+// identifier or nil if there is none.
+func (device *Device) ManagedApp(identifier string) (ma *ManagedApp, err error) {
+	var mab []byte
+	err = device.boltDB.View(func(tx *bolt.Tx) error {
+		mab = BucketGet(tx, "device_managed_apps", device.appKey(identifier))
+		return nil
+	})
+	if err != nil || len(mab) == 0 {
+		return
+	}
+	ma = &ManagedApp{}
+	err = json.Unmarshal(mab, ma)
+	return
+}
+
+// ManagedApps returns the app installs requested by the MDM server
+// ordered by identifier.
+func (device *Device) ManagedApps() (mas []*ManagedApp, err error) {
+	err = device.boltDB.View(func(tx *bolt.Tx) error {
+		prefix := device.UDID + "_"
+		for _, id := range BucketGetKeysWithPrefix(tx, "device_managed_apps", prefix, true) {
+			ma := &ManagedApp{}
+			if err := json.Unmarshal(BucketGet(tx, "device_managed_apps", prefix+id), ma); err != nil {
+				return fmt.Errorf("managed app %s: %w", id, err)
+			}
+			mas = append(mas, ma)
+		}
+		return nil
+	})
+	return
+}
+
+// advanceManagedApps moves pending managed app installs to their next
+// state. Apps that reach the Managed state are installed.
+func (device *Device) advanceManagedApps() error {
+	mas, err := device.ManagedApps()
+	if err != nil {
+		return err
+	}
+	for _, ma := range mas {
+		next, ok := nextAppState[ma.State]
+		if !ok {
+			continue
+		}
+		ma.State = next
+		if next == AppStateManaged {
+			ma.Managed = true
+			if err = device.InstallApp(&ma.App); err != nil {
+				return err
+			}
+		}
+		if err = device.saveManagedApp(ma); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appManifest is an app (enterprise distribution) manifest.
+type appManifest struct {
+	Items []struct {
+		Metadata struct {
+			BundleIdentifier string `plist:"bundle-identifier"`
+			BundleVersion    string `plist:"bundle-version"`
+			Title            string `plist:"title"`
+		} `plist:"metadata"`
+	} `plist:"items"`
+}
+
+// fetchAppManifest downloads the app manifest at url and updates ma
+// from its metadata.
+func (device *Device) fetchAppManifest(ctx context.Context, url string, ma *ManagedApp) error {
+	b, err := device.httpGet(ctx, "manifest", url)
+	if err != nil {
+		return err
+	}
+	manifest := &appManifest{}
+	if err = plist.Unmarshal(b, manifest); err != nil {
+		return err
+	}
+	if len(manifest.Items) < 1 {
+		return errors.New("no items in app manifest")
+	}
+	md := manifest.Items[0].Metadata
+	if ma.Identifier == "" {
+		ma.Identifier = md.BundleIdentifier
+	}
+	ma.Name = md.Title
+	ma.Version = md.BundleVersion
+	ma.ShortVersion = md.BundleVersion
+	return nil
+}
@@ -3,11 +3,15 @@ package device
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/groob/plist"
 )
 
@@ -45,6 +49,10 @@ type AuthenticationRequest struct {
 	EnrollmentID string `plist:",omitempty"` // macOS 10.15 and iOS 13.0 and later
 }
 
+func (r *AuthenticationRequest) checkinMessageType() string {
+	return r.MessageType
+}
+
 type ErrorChain struct {
 	ErrorCode            int
 	ErrorDomain          string
@@ -61,6 +69,10 @@ type ConnectRequest struct {
 	RequestType string `plist:",omitempty"`
 }
 
+func (r *ConnectRequest) connectStatus() string {
+	return r.Status
+}
+
 // type ConnectResponse struct {
 // 	Command     interface{}
 // 	CommandUUID string
@@ -86,6 +98,26 @@ type TokenUpdateRequest struct {
 	UserLongName          string `plist:",omitempty"`
 }
 
+func (r *TokenUpdateRequest) checkinMessageType() string {
+	return r.MessageType
+}
+
+// errUnenrolled is returned by MDM command handlers that unenrolled the
+// device and so can't respond.
+var errUnenrolled = errors.New("device unenrolled")
+
+// HTTPStatusError is returned when an MDM request fails with a non-200
+// HTTP response.
+type HTTPStatusError struct {
+	Request    string
+	StatusCode int
+	Status     string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("%s request failed with HTTP status: %s", e.Request, e.Status)
+}
+
 // PlistReader encodes i into XML Plist and returns a reader.
 func PlistReader(i interface{}) (io.Reader, error) {
 	buf := new(bytes.Buffer)
@@ -96,33 +128,93 @@ func PlistReader(i interface{}) (io.Reader, error) {
 }
 
 func (c *MDMClient) checkinRequest(ctx context.Context, i interface{}) error {
+	_, err := c.checkinRequestWithResponse(ctx, i)
+	return err
+}
+
+// checkinRequestWithResponse sends a check-in message and returns the
+// response body.
+func (c *MDMClient) checkinRequestWithResponse(ctx context.Context, i interface{}) (respBytes []byte, err error) {
+	if mt, ok := i.(interface{ checkinMessageType() string }); ok {
+		defer func(started time.Time) {
+			contextTrace(ctx).checkIn(mt.checkinMessageType(), time.Since(started), err)
+		}(time.Now())
+	}
+
 	r, err := PlistReader(i)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	resp, err := c.transport.DoCheckIn(ctx, r)
+	tctx, timer := withHTTPTimer(ctx)
+	resp, err := c.transport.DoCheckIn(tctx, r)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
+	contextTrace(ctx).httpResponse("checkin", resp.StatusCode)
+
+	respBytes, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	timer.done(ctx, "checkin")
 
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("checkin request failed with HTTP status: %s", resp.Status)
+		return nil, &HTTPStatusError{Request: "checkin", StatusCode: resp.StatusCode, Status: resp.Status}
 	}
 
-	return nil
+	return respBytes, nil
 }
 
+// fakePushValues derives a device-unique (fake) APNs push token and push
+// magic. Push tokens are the same size as real APNs tokens.
+func fakePushValues(udid, addl string) ([]byte, string) {
+	token := sha256.Sum256([]byte("fakeToken" + udid + addl))
+	pushMagic := uuid.NewSHA1(uuid.NameSpaceOID, []byte("fakePushMagic"+udid+addl))
+	return token[:], strings.ToUpper(pushMagic.String())
+}
+
+// TokenUpdate sends a TokenUpdate check-in message to the MDM server.
+// The push token and push magic sent are saved with the device.
 func (c *MDMClient) TokenUpdate(ctx context.Context, addl string) error {
+	token, pushMagic := fakePushValues(c.Device.UDID, addl)
 	tu := &TokenUpdateRequest{
 		MessageType: "TokenUpdate",
-		PushMagic:   "fakePushMagic" + addl,
-		Token:       []byte("fakeToken" + addl),
+		PushMagic:   pushMagic,
+		Token:       token,
+		Topic:       c.MDMPayload.Topic,
+		UDID:        c.Device.UDID,
+	}
+	err := c.checkinRequest(ctx, tu)
+	if err != nil {
+		return err
+	}
+	c.Device.PushMagic = pushMagic
+	c.Device.PushToken = token
+	return c.Device.Save()
+}
+
+// CheckOutRequest ...
+type CheckOutRequest struct {
+	EnrollmentID string `plist:",omitempty"` // macOS 10.15 and iOS 13.0 and later
+	MessageType  string
+	Topic        string
+	UDID         string
+}
+
+func (r *CheckOutRequest) checkinMessageType() string {
+	return r.MessageType
+}
+
+// checkOut sends a CheckOut check-in message to the MDM server.
+func (c *MDMClient) checkOut(ctx context.Context) error {
+	co := &CheckOutRequest{
+		MessageType: "CheckOut",
 		Topic:       c.MDMPayload.Topic,
 		UDID:        c.Device.UDID,
 	}
-	return c.checkinRequest(ctx, tu)
+	return c.checkinRequest(ctx, co)
 }
 
 type ConnectResponseCommand struct {
@@ -135,11 +227,54 @@ type ConnectResponse struct {
 }
 
 func (c *MDMClient) Connect(ctx context.Context) error {
+	if !c.enrolled() {
+		return errors.New("device not enrolled")
+	}
+	// progress app installs and OS updates requested in earlier
+	// connects
+	if err := c.Device.advanceManagedApps(); err != nil {
+		return err
+	}
+	updated, err := c.Device.advanceOSUpdate()
+	if err != nil {
+		return err
+	}
+	if updated {
+		if err = c.restarted(ctx); err != nil {
+			return fmt.Errorf("OS update restart: %w", err)
+		}
+	}
 	req := &ConnectRequest{
 		UDID:   c.Device.UDID,
 		Status: "Idle",
 	}
-	return c.connect(ctx, req)
+	err = c.connect(ctx, req)
+	if err != nil || !c.ddmSync {
+		return err
+	}
+	// like a real device synchronize after acknowledging the
+	// DeclarativeManagement command
+	c.ddmSync = false
+	if err = c.SyncDeclarations(ctx, c.ddmSyncTokens); err != nil {
+		return fmt.Errorf("declarative management sync: %w", err)
+	}
+	return nil
+}
+
+// restarted sends the check-in messages of a device restarted into a
+// new OS version: Authenticate and TokenUpdate (with the new OS
+// version) and a DDM status report if the device uses DDM.
+func (c *MDMClient) restarted(ctx context.Context) error {
+	if err := c.authenticate(ctx); err != nil {
+		return err
+	}
+	if err := c.TokenUpdate(ctx, ""); err != nil {
+		return err
+	}
+	if c.Device.DeclarationsToken == "" {
+		return nil
+	}
+	return c.SendStatusReport(ctx, false)
 }
 
 func (c *MDMClient) connect(ctx context.Context, connReq interface{}) error {
@@ -152,19 +287,22 @@ func (c *MDMClient) connect(ctx context.Context, connReq interface{}) error {
 		return err
 	}
 
-	res, err := c.transport.DoReportResultsAndFetchNextCommand(ctx, r)
+	tctx, timer := withHTTPTimer(ctx)
+	res, err := c.transport.DoReportResultsAndFetchNextCommand(tctx, r)
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
+	contextTrace(ctx).httpResponse("connect", res.StatusCode)
 
 	respBytes, err := io.ReadAll(res.Body)
 	if err != nil {
 		return err
 	}
+	timer.done(ctx, "connect")
 
 	if res.StatusCode != 200 {
-		return fmt.Errorf("connect request failed with HTTP status: %s", res.Status)
+		return &HTTPStatusError{Request: "connect", StatusCode: res.StatusCode, Status: res.Status}
 	}
 
 	if len(respBytes) == 0 {
@@ -178,7 +316,14 @@ func (c *MDMClient) connect(ctx context.Context, connReq interface{}) error {
 	}
 
 	nextConnReq, err := c.handleMDMCommand(ctx, resp.Command.RequestType, resp.CommandUUID, respBytes)
-	if err != nil {
+	if errors.Is(err, errUnenrolled) {
+		// like a real device there's no response to a command that
+		// removes the MDM enrollment
+		return nil
+	} else if errors.Is(err, errDropped) {
+		contextTrace(ctx).commandHandled(resp.Command.RequestType, "Dropped")
+		return nil
+	} else if err != nil {
 		log.Println(err)
 		nextConnReq = &ConnectRequest{
 			UDID:        c.Device.UDID,
@@ -212,5 +357,9 @@ func (c *MDMClient) connect(ctx context.Context, connReq interface{}) error {
 		}
 	}
 
+	if cs, ok := nextConnReq.(interface{ connectStatus() string }); ok {
+		contextTrace(ctx).commandHandled(resp.Command.RequestType, cs.connectStatus())
+	}
+
 	return c.connect(ctx, nextConnReq)
 }
@@ -6,6 +6,7 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"errors"
+	"fmt"
 
 	"github.com/jessepeterson/cfgprofiles"
 	"github.com/jessepeterson/mdmb/protocol"
@@ -20,7 +21,10 @@ type MDMClient struct {
 
 	transport *protocol.Transport
 
-	notNow bool
+	// DDM server tokens of a DeclarativeManagement command to
+	// synchronize with after the current connect
+	ddmSync       bool
+	ddmSyncTokens *SyncTokens
 }
 
 func (c *MDMClient) loadIdentityFromKeychain(uuid string) error {
@@ -51,6 +55,11 @@ func newMDMClientUsingPayload(device *Device, mdmPld *cfgprofiles.MDMPayload) (*
 	c := &MDMClient{Device: device, MDMPayload: mdmPld}
 	err := c.loadIdentityFromKeychain(device.MDMIdentityKeychainUUID)
 	if err == nil {
+		// don't reuse client certificate connections of a previous
+		// identity
+		if cc, ok := device.clientCertHTTPClient.(interface{ CloseIdleConnections() }); ok {
+			cc.CloseIdleConnections()
+		}
 		c.configureTransport()
 	}
 	return c, err
@@ -67,8 +76,17 @@ func (c *MDMClient) configureTransport() {
 		}),
 		protocol.WithMDMURLs(c.MDMPayload.ServerURL, c.MDMPayload.CheckInURL),
 	}
+	doer := c.Device.httpClient
 	if c.MDMPayload.SignMessage {
 		tOpts = append(tOpts, protocol.WithSignMessage())
+	} else {
+		tOpts = append(tOpts, protocol.WithClientCertificate())
+		if cc := c.Device.clientCertClient(); cc != nil {
+			doer = cc
+		}
+	}
+	if doer != nil {
+		tOpts = append(tOpts, protocol.WithClient(doer))
 	}
 	c.transport = protocol.NewTransport(tOpts...)
 }
@@ -113,9 +131,6 @@ func (c *MDMClient) enroll(ctx context.Context, profileID string) error {
 	if c.MDMPayload == nil {
 		return errors.New("no MDM payload")
 	}
-	if !c.MDMPayload.SignMessage {
-		return errors.New("non-SignMessage (mTLS) enrollment not supported")
-	}
 
 	err := c.authenticate(ctx)
 	if err != nil {
@@ -131,14 +146,29 @@ func (c *MDMClient) enroll(ctx context.Context, profileID string) error {
 	return nil
 }
 
-func (c *MDMClient) unenroll() error {
-	// c.MDMPayload.CheckOutWhenRemoved
+// unenroll removes the MDM enrollment, first checking out from the
+// MDM server if configured to.
+func (c *MDMClient) unenroll(ctx context.Context) error {
+	var err error
+	if c.MDMPayload != nil && c.MDMPayload.CheckOutWhenRemoved && c.transport != nil {
+		// like a real device unenroll whether or not the check-out
+		// succeeds
+		if err = c.checkOut(ctx); err != nil {
+			err = fmt.Errorf("check-out: %w", err)
+		}
+	}
 	c.IdentityPrivateKey = nil
 	c.IdentityCertificate = nil
 	c.MDMPayload = nil
 	c.Device.MDMProfileIdentifier = ""
 	c.Device.MDMIdentityKeychainUUID = ""
-	return nil
+	c.Device.PushMagic = ""
+	c.Device.PushToken = nil
+	c.Device.DeclarationsToken = ""
+	if rmErr := c.Device.removeDeclarations(); rmErr != nil && err == nil {
+		err = rmErr
+	}
+	return err
 }
 
 func (c *MDMClient) enrolled() bool {
@@ -0,0 +1,229 @@
+package device
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// OS update statuses (as reported in OSUpdateStatus)
+const (
+	OSUpdateStatusIdle        = "Idle"
+	OSUpdateStatusDownloading = "Downloading"
+	OSUpdateStatusInstalling  = "Installing"
+)
+
+// OS update install actions of ScheduleOSUpdate
+const (
+	OSUpdateActionDefault      = "Default"
+	OSUpdateActionDownloadOnly = "DownloadOnly"
+	OSUpdateActionInstallASAP  = "InstallASAP"
+	OSUpdateActionNotifyOnly   = "NotifyOnly"
+	OSUpdateActionInstallLater = "InstallLater"
+	OSUpdateActionForceRestart = "InstallForceRestart"
+)
+
+// osUpdateDownloadStep is the fraction of an OS update downloaded on
+// each MDM connect.
+const osUpdateDownloadStep = 0.5
+
+// OSUpdate is a (simulated) OS update available to the device.
+type OSUpdate struct {
+	ProductKey        string `json:"product_key"`
+	HumanReadableName string `json:"name,omitempty"`
+	Version           string `json:"version"`
+	Build             string `json:"build"`
+	DownloadSize      int64  `json:"download_size,omitempty"`
+	InstallSize       int64  `json:"install_size,omitempty"`
+	IsCritical        bool   `json:"critical,omitempty"`
+	IsMajorOSUpdate   bool   `json:"major,omitempty"`
+}
+
+// ScheduledOSUpdate is the progress of an OS update scheduled by the
+// MDM server.
+type ScheduledOSUpdate struct {
+	OSUpdate
+
+	InstallAction   string  `json:"install_action"`
+	Status          string  `json:"status"`
+	PercentComplete float64 `json:"percent_complete"`
+	IsDownloaded    bool    `json:"is_downloaded,omitempty"`
+}
+
+// OSUpdates returns the OS update catalog of the device.
+func (device *Device) OSUpdates() (updates []OSUpdate, err error) {
+	err = device.boltDB.View(func(tx *bolt.Tx) error {
+		b := BucketGet(tx, "device_os_updates", device.UDID)
+		if len(b) == 0 {
+			return nil
+		}
+		return json.Unmarshal(b, &updates)
+	})
+	return
+}
+
+// SetOSUpdates sets the OS update catalog of the device. Only updates
+// newer than the OS version of the device are available.
+func (device *Device) SetOSUpdates(updates []OSUpdate) error {
+	var b []byte
+	if len(updates) > 0 {
+		var err error
+		if b, err = json.Marshal(updates); err != nil {
+			return err
+		}
+	}
+	return device.boltDB.Update(func(tx *bolt.Tx) error {
+		return BucketPutOrDelete(tx, "device_os_updates", device.UDID, b)
+	})
+}
+
+// AvailableOSUpdates returns the updates of the OS update catalog newer
+// than the OS version of the device.
+func (device *Device) AvailableOSUpdates() ([]OSUpdate, error) {
+	updates, err := device.OSUpdates()
+	if err != nil {
+		return nil, err
+	}
+	var available []OSUpdate
+	for _, u := range updates {
+		if compareVersions(u.Version, device.OSVersion) > 0 {
+			available = append(available, u)
+		}
+	}
+	return available, nil
+}
+
+// ScheduledOSUpdate returns the OS update scheduled by the MDM server or
+// nil if there is none.
+func (device *Device) ScheduledOSUpdate() (su *ScheduledOSUpdate, err error) {
+	var b []byte
+	err = device.boltDB.View(func(tx *bolt.Tx) error {
+		b = BucketGet(tx, "device_os_update_scheduled", device.UDID)
+		return nil
+	})
+	if err != nil || len(b) == 0 {
+		return
+	}
+	su = &ScheduledOSUpdate{}
+	err = json.Unmarshal(b, su)
+	return
+}
+
+func (device *Device) saveScheduledOSUpdate(su *ScheduledOSUpdate) error {
+	var b []byte
+	if su != nil {
+		var err error
+		if b, err = json.Marshal(su); err != nil {
+			return err
+		}
+	}
+	return device.boltDB.Update(func(tx *bolt.Tx) error {
+		return BucketPutOrDelete(tx, "device_os_update_scheduled", device.UDID, b)
+	})
+}
+
+// scheduleOSUpdate schedules u with installAction replacing any
+// previously scheduled update. An update already downloaded is
+// installed right away unless only downloading.
+func (device *Device) scheduleOSUpdate(u OSUpdate, installAction string) (*ScheduledOSUpdate, error) {
+	prev, err := device.ScheduledOSUpdate()
+	if err != nil {
+		return nil, err
+	}
+	su := &ScheduledOSUpdate{
+		OSUpdate:      u,
+		InstallAction: installAction,
+		Status:        OSUpdateStatusDownloading,
+	}
+	if prev != nil && prev.ProductKey == u.ProductKey {
+		su.PercentComplete = prev.PercentComplete
+		su.IsDownloaded = prev.IsDownloaded
+	}
+	if su.IsDownloaded {
+		su.Status = OSUpdateStatusIdle
+		if osUpdateInstalls(installAction) {
+			su.Status = OSUpdateStatusInstalling
+		}
+	}
+	return su, device.saveScheduledOSUpdate(su)
+}
+
+// osUpdateInstalls reports whether installAction installs the update
+// after downloading it (vs. leaving it for the user to install).
+func osUpdateInstalls(installAction string) bool {
+	switch installAction {
+	case OSUpdateActionDownloadOnly, OSUpdateActionNotifyOnly, OSUpdateActionInstallLater:
+		return false
+	}
+	return true
+}
+
+// advanceOSUpdate progresses the scheduled OS update: it downloads in
+// steps and then installs. It reports whether the update was installed
+// in which case the device has the OS version of the update (as if it
+// restarted).
+func (device *Device) advanceOSUpdate() (bool, error) {
+	su, err := device.ScheduledOSUpdate()
+	if err != nil || su == nil {
+		return false, err
+	}
+	switch su.Status {
+	case OSUpdateStatusDownloading:
+		su.PercentComplete += osUpdateDownloadStep
+		if su.PercentComplete >= 1 {
+			su.PercentComplete = 1
+			su.IsDownloaded = true
+			su.Status = OSUpdateStatusIdle
+			if osUpdateInstalls(su.InstallAction) {
+				su.Status = OSUpdateStatusInstalling
+			}
+		}
+		return false, device.saveScheduledOSUpdate(su)
+	case OSUpdateStatusInstalling:
+		device.OSVersion = su.Version
+		device.BuildVersion = su.Build
+		if err = device.Save(); err != nil {
+			return false, err
+		}
+		return true, device.saveScheduledOSUpdate(nil)
+	}
+	return false, nil
+}
+
+// softwareUpdateInstallState returns the DDM
+// softwareupdate.install-state of the scheduled OS update.
+func (su *ScheduledOSUpdate) softwareUpdateInstallState() string {
+	switch {
+	case su == nil:
+		return "none"
+	case su.Status == OSUpdateStatusInstalling:
+		return "installing"
+	case su.IsDownloaded:
+		return "downloaded"
+	}
+	return "downloading"
+}
+
+// compareVersions compares dotted numeric versions (e.g. "15.4.1").
+// Missing components are zero.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var an, bn int
+		if i < len(as) {
+			an, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			bn, _ = strconv.Atoi(bs[i])
+		}
+		switch {
+		case an < bn:
+			return -1
+		case an > bn:
+			return 1
+		}
+	}
+	return 0
+}
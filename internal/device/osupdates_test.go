@@ -0,0 +1,28 @@
+package device
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	for _, test := range []struct {
+		a, b string
+		want int
+	}{
+		{"15.5", "15.5", 0},
+		{"15.5", "15.4", 1},
+		{"15.4", "15.5", -1},
+		{"15.4.1", "15.4", 1},
+		{"15.4", "15.4.1", -1},
+		{"15", "15.0", 0},
+		{"15.0.0", "15", 0},
+		{"15.10", "15.9", 1},
+		{"9.0", "10.0", -1},
+		{"26.0", "15.7.1", 1},
+		{"", "", 0},
+		{"", "1", -1},
+		{"1", "", 1},
+	} {
+		if have := compareVersions(test.a, test.b); have != test.want {
+			t.Errorf("compare %q %q: have %d, want %d", test.a, test.b, have, test.want)
+		}
+	}
+}
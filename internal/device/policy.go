@@ -0,0 +1,176 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Command response policy responses
+const (
+	// handle the command (after any delay)
+	ResponseAcknowledged = "Acknowledged"
+	ResponseNotNow       = "NotNow"
+	ResponseError        = "Error"
+	// don't respond to the command
+	ResponseDrop = "Drop"
+)
+
+// Duration is a time.Duration that is a duration string (e.g. "1.5s")
+// in JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	pd, err := time.ParseDuration(s)
+	*d = Duration(pd)
+	return err
+}
+
+// ResponsePolicy selects how a device responds to MDM commands of
+// RequestType (or all commands if empty or "*"). The policy applies
+// with Probability (always if zero) after an artificial handling
+// Delay.
+type ResponsePolicy struct {
+	RequestType string       `json:"request_type,omitempty"`
+	Response    string       `json:"response"`
+	Probability float64      `json:"probability,omitempty"`
+	Delay       Duration     `json:"delay,omitempty"`
+	ErrorChain  []ErrorChain `json:"error_chain,omitempty"`
+}
+
+// Validate checks the response and probability of the policy.
+func (p *ResponsePolicy) Validate() error {
+	switch p.Response {
+	case ResponseAcknowledged, ResponseNotNow, ResponseError, ResponseDrop:
+	default:
+		return fmt.Errorf("invalid response: %q", p.Response)
+	}
+	if p.Probability < 0 || p.Probability > 1 {
+		return fmt.Errorf("invalid probability: %v", p.Probability)
+	}
+	if p.Delay < 0 {
+		return errors.New("negative delay")
+	}
+	return nil
+}
+
+func (p *ResponsePolicy) matches(requestType string) bool {
+	if p.RequestType != "" && p.RequestType != "*" && p.RequestType != requestType {
+		return false
+	}
+	return p.Probability == 0 || rand.Float64() < p.Probability
+}
+
+// errorChain returns the error chain of an Error response.
+func (p *ResponsePolicy) errorChain(requestType string) []ErrorChain {
+	if len(p.ErrorChain) > 0 {
+		return p.ErrorChain
+	}
+	return []ErrorChain{{
+		ErrorCode:            99997,
+		ErrorDomain:          "mdmb-response-policy",
+		LocalizedDescription: "Error response policy for " + requestType,
+	}}
+}
+
+// WithResponsePolicies configures fleet-wide command response policies
+// of the device. They apply after the policies of the device itself.
+func WithResponsePolicies(policies []ResponsePolicy) Option {
+	return func(d *Device) {
+		d.responsePolicies = policies
+	}
+}
+
+// ResponsePolicies returns the command response policies of the device.
+func (device *Device) ResponsePolicies() (policies []ResponsePolicy, err error) {
+	err = device.boltDB.View(func(tx *bolt.Tx) error {
+		b := BucketGet(tx, "device_response_policies", device.UDID)
+		if len(b) == 0 {
+			return nil
+		}
+		return json.Unmarshal(b, &policies)
+	})
+	return
+}
+
+// SetResponsePolicies sets the command response policies of the device.
+// They are evaluated in order and the first policy that matches (and
+// passes its probability) selects the response. Commands are handled
+// normally if no policy matches.
+func (device *Device) SetResponsePolicies(policies []ResponsePolicy) error {
+	var b []byte
+	if len(policies) > 0 {
+		var err error
+		if b, err = json.Marshal(policies); err != nil {
+			return err
+		}
+	}
+	return device.boltDB.Update(func(tx *bolt.Tx) error {
+		return BucketPutOrDelete(tx, "device_response_policies", device.UDID, b)
+	})
+}
+
+// responsePolicy selects the response policy for a command of
+// requestType or returns nil if no policy matches.
+func (device *Device) responsePolicy(requestType string) (*ResponsePolicy, error) {
+	policies, err := device.ResponsePolicies()
+	if err != nil {
+		return nil, err
+	}
+	for _, ps := range [][]ResponsePolicy{policies, device.responsePolicies} {
+		for i := range ps {
+			if ps[i].matches(requestType) {
+				return &ps[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// errDropped is returned by MDM command handlers when a response policy
+// drops the command response.
+var errDropped = errors.New("command response dropped")
+
+// applyResponsePolicy applies the response policy for a command. It
+// returns the response to send instead of handling the command, if
+// any.
+func (c *MDMClient) applyResponsePolicy(ctx context.Context, reqType, commandUUID string) (interface{}, error) {
+	policy, err := c.Device.responsePolicy(reqType)
+	if err != nil || policy == nil {
+		return nil, err
+	}
+	if policy.Delay > 0 {
+		select {
+		case <-time.After(time.Duration(policy.Delay)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	switch policy.Response {
+	case ResponseNotNow:
+		return &ConnectRequest{
+			UDID:        c.Device.UDID,
+			CommandUUID: commandUUID,
+			Status:      "NotNow",
+			RequestType: reqType,
+		}, nil
+	case ResponseError:
+		return c.errorResponse(reqType, commandUUID, policy.errorChain(reqType)...), nil
+	case ResponseDrop:
+		return nil, errDropped
+	}
+	return nil, nil
+}
@@ -0,0 +1,114 @@
+package device
+
+import (
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestResponsePolicyMatches(t *testing.T) {
+	for _, test := range []struct {
+		policy      ResponsePolicy
+		requestType string
+		want        bool
+	}{
+		{ResponsePolicy{}, "ProfileList", true},
+		{ResponsePolicy{RequestType: "*"}, "ProfileList", true},
+		{ResponsePolicy{RequestType: "ProfileList"}, "ProfileList", true},
+		{ResponsePolicy{RequestType: "ProfileList"}, "InstallProfile", false},
+		{ResponsePolicy{RequestType: "profilelist"}, "ProfileList", false},
+		{ResponsePolicy{RequestType: "ProfileList", Probability: 1}, "ProfileList", true},
+		{ResponsePolicy{RequestType: "ProfileList", Probability: 1}, "InstallProfile", false},
+	} {
+		if have := test.policy.matches(test.requestType); have != test.want {
+			t.Errorf("policy %+v matches %s: have %v, want %v", test.policy, test.requestType, have, test.want)
+		}
+	}
+
+	// probability
+	p := &ResponsePolicy{Probability: 0.5}
+	var matched int
+	for i := 0; i < 10000; i++ {
+		if p.matches("ProfileList") {
+			matched++
+		}
+	}
+	if matched < 4000 || matched > 6000 {
+		t.Errorf("probability 0.5 matched %d of 10000", matched)
+	}
+}
+
+func TestResponsePolicyValidate(t *testing.T) {
+	for _, test := range []struct {
+		policy ResponsePolicy
+		valid  bool
+	}{
+		{ResponsePolicy{Response: ResponseAcknowledged}, true},
+		{ResponsePolicy{Response: ResponseNotNow, Probability: 1}, true},
+		{ResponsePolicy{Response: ResponseError, Delay: Duration(1)}, true},
+		{ResponsePolicy{Response: ResponseDrop}, true},
+		{ResponsePolicy{}, false},
+		{ResponsePolicy{Response: "notnow"}, false},
+		{ResponsePolicy{Response: ResponseNotNow, Probability: -0.1}, false},
+		{ResponsePolicy{Response: ResponseNotNow, Probability: 1.1}, false},
+		{ResponsePolicy{Response: ResponseNotNow, Delay: Duration(-1)}, false},
+	} {
+		if err := test.policy.Validate(); (err == nil) != test.valid {
+			t.Errorf("validate %+v: have %v, want valid %v", test.policy, err, test.valid)
+		}
+	}
+}
+
+func TestDeviceResponsePolicy(t *testing.T) {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "mdmb.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	dev := New("test", db, WithResponsePolicies([]ResponsePolicy{
+		{RequestType: "ProfileList", Response: ResponseError},
+		{Response: ResponseAcknowledged},
+	}))
+	err = dev.SetResponsePolicies([]ResponsePolicy{
+		{RequestType: "ProfileList", Response: ResponseDrop, Probability: 0.000001},
+		{RequestType: "ProfileList", Response: ResponseNotNow},
+		{RequestType: "DeviceInformation", Response: ResponseDrop},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		requestType string
+		want        string
+	}{
+		// device policies are evaluated first and in order
+		{"ProfileList", ResponseNotNow},
+		{"DeviceInformation", ResponseDrop},
+		// then the fleet policies
+		{"InstallProfile", ResponseAcknowledged},
+	} {
+		policy, err := dev.responsePolicy(test.requestType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if policy == nil {
+			t.Errorf("%s: no policy, want %s", test.requestType, test.want)
+			continue
+		}
+		if have := policy.Response; have != test.want {
+			t.Errorf("%s: have %s, want %s", test.requestType, have, test.want)
+		}
+	}
+
+	if err = dev.SetResponsePolicies(nil); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := dev.responsePolicy("ProfileList")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy == nil || policy.Response != ResponseError {
+		t.Errorf("ProfileList: have %+v, want fleet %s policy", policy, ResponseError)
+	}
+}
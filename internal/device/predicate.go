@@ -0,0 +1,271 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// evalPredicate evaluates a DDM activation predicate against the
+// status items of a device. A subset of the NSPredicate syntax is
+// supported: comparisons (==, !=, <, <=, >, >=, BEGINSWITH, ENDSWITH,
+// CONTAINS, IN) of @status(<item>) values and literals combined with
+// AND, OR, NOT, and parentheses.
+func evalPredicate(predicate string, status map[string]interface{}) (bool, error) {
+	tokens, err := tokenizePredicate(predicate)
+	if err != nil {
+		return false, err
+	}
+	p := &predicateParser{tokens: tokens, status: status}
+	v, err := p.or()
+	if err != nil {
+		return false, err
+	}
+	if p.pos < len(p.tokens) {
+		return false, fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	return v, nil
+}
+
+type predicateToken struct {
+	text   string
+	quoted bool // string literal
+}
+
+func tokenizePredicate(s string) (tokens []predicateToken, err error) {
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return nil, errors.New("unterminated string")
+			}
+			tokens = append(tokens, predicateToken{text: s[i+1 : i+1+end], quoted: true})
+			i += end + 2
+		case strings.ContainsRune("(){},", rune(c)):
+			tokens = append(tokens, predicateToken{text: string(c)})
+			i++
+		case strings.ContainsRune("=!<>&|", rune(c)):
+			j := i + 1
+			for j < len(s) && strings.ContainsRune("=<>&|", rune(s[j])) {
+				j++
+			}
+			tokens = append(tokens, predicateToken{text: s[i:j]})
+			i = j
+		default:
+			j := i
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || strings.ContainsRune("@.-_", rune(s[j]))) {
+				j++
+			}
+			if j == i {
+				return nil, fmt.Errorf("unexpected character %q", c)
+			}
+			tokens = append(tokens, predicateToken{text: s[i:j]})
+			i = j
+		}
+	}
+	return
+}
+
+type predicateParser struct {
+	tokens []predicateToken
+	pos    int
+	status map[string]interface{}
+}
+
+// accept consumes the next token if it is one of words (case
+// insensitive, not quoted).
+func (p *predicateParser) accept(words ...string) bool {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].quoted {
+		return false
+	}
+	for _, w := range words {
+		if strings.EqualFold(p.tokens[p.pos].text, w) {
+			p.pos++
+			return true
+		}
+	}
+	return false
+}
+
+func (p *predicateParser) or() (bool, error) {
+	v, err := p.and()
+	for err == nil && p.accept("OR", "||") {
+		var r bool
+		r, err = p.and()
+		v = v || r
+	}
+	return v, err
+}
+
+func (p *predicateParser) and() (bool, error) {
+	v, err := p.not()
+	for err == nil && p.accept("AND", "&&") {
+		var r bool
+		r, err = p.not()
+		v = v && r
+	}
+	return v, err
+}
+
+func (p *predicateParser) not() (bool, error) {
+	if p.accept("NOT", "!") {
+		v, err := p.not()
+		return !v, err
+	}
+	if p.accept("(") {
+		v, err := p.or()
+		if err == nil && !p.accept(")") {
+			err = errors.New("missing )")
+		}
+		return v, err
+	}
+	if p.accept("TRUEPREDICATE") {
+		return true, nil
+	}
+	if p.accept("FALSEPREDICATE") {
+		return false, nil
+	}
+	return p.comparison()
+}
+
+func (p *predicateParser) comparison() (bool, error) {
+	left, err := p.operand()
+	if err != nil {
+		return false, err
+	}
+	if p.pos >= len(p.tokens) {
+		return false, errors.New("missing comparison operator")
+	}
+	op := strings.ToUpper(p.tokens[p.pos].text)
+	p.pos++
+	if op == "IN" {
+		values, err := p.list()
+		if err != nil {
+			return false, err
+		}
+		for _, v := range values {
+			if compareValues(left, v) == 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	right, err := p.operand()
+	if err != nil {
+		return false, err
+	}
+	ls, rs := fmt.Sprint(left), fmt.Sprint(right)
+	switch op {
+	case "==", "=":
+		return compareValues(left, right) == 0, nil
+	case "!=", "<>":
+		return compareValues(left, right) != 0, nil
+	case "<":
+		return compareValues(left, right) < 0, nil
+	case "<=", "=<":
+		return compareValues(left, right) <= 0, nil
+	case ">":
+		return compareValues(left, right) > 0, nil
+	case ">=", "=>":
+		return compareValues(left, right) >= 0, nil
+	case "BEGINSWITH":
+		return strings.HasPrefix(ls, rs), nil
+	case "ENDSWITH":
+		return strings.HasSuffix(ls, rs), nil
+	case "CONTAINS":
+		return strings.Contains(ls, rs), nil
+	}
+	return false, fmt.Errorf("unknown operator %q", op)
+}
+
+func (p *predicateParser) list() (values []interface{}, err error) {
+	if !p.accept("{") {
+		return nil, errors.New("IN requires a list")
+	}
+	for !p.accept("}") {
+		if len(values) > 0 && !p.accept(",") {
+			return nil, errors.New("missing , in list")
+		}
+		v, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return
+}
+
+func (p *predicateParser) operand() (interface{}, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, errors.New("missing operand")
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	if t.quoted {
+		return t.text, nil
+	}
+	if strings.EqualFold(t.text, "@status") {
+		if !p.accept("(") || p.pos >= len(p.tokens) {
+			return nil, errors.New("invalid @status")
+		}
+		key := p.tokens[p.pos].text
+		p.pos++
+		if !p.accept(")") {
+			return nil, errors.New("missing ) of @status")
+		}
+		return p.status[key], nil
+	}
+	switch strings.ToUpper(t.text) {
+	case "TRUE", "YES":
+		return true, nil
+	case "FALSE", "NO":
+		return false, nil
+	case "NIL", "NULL":
+		return nil, nil
+	}
+	if f, err := strconv.ParseFloat(t.text, 64); err == nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
+
+// compareValues compares numbers numerically and everything else as
+// strings.
+func compareValues(a, b interface{}) int {
+	af, aok := toFloat(a)
+	bf, bok := toFloat(b)
+	if aok && bok {
+		switch {
+		case af < bf:
+			return -1
+		case af > bf:
+			return 1
+		}
+		return 0
+	}
+	if a == nil || b == nil {
+		if a == b {
+			return 0
+		}
+		return 1
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
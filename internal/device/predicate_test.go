@@ -0,0 +1,114 @@
+package device
+
+import "testing"
+
+func TestEvalPredicate(t *testing.T) {
+	status := map[string]interface{}{
+		"device.model.family":             "Mac",
+		"device.operating-system.version": "15.5",
+		"passcode.is-present":             true,
+		"passcode.is-compliant":           false,
+		"test.number":                     3.0,
+		"test.int":                        7,
+	}
+	for _, test := range []struct {
+		predicate string
+		want      bool
+	}{
+		// comparisons
+		{`@status(device.model.family) == "Mac"`, true},
+		{`@status(device.model.family) = "Mac"`, true},
+		{`@status(device.model.family) == "iPhone"`, false},
+		{`@status(device.model.family) != "iPhone"`, true},
+		{`@status(device.model.family) <> "Mac"`, false},
+		{`@status(test.number) < 4`, true},
+		{`@status(test.number) <= 3`, true},
+		{`@status(test.number) =< 2`, false},
+		{`@status(test.number) > 3`, false},
+		{`@status(test.number) >= 3`, true},
+		{`@status(test.number) => 3.5`, false},
+		{`@status(test.int) == 7`, true},
+		{`@status(device.operating-system.version) BEGINSWITH "15."`, true},
+		{`@status(device.operating-system.version) beginswith "14."`, false},
+		{`@status(device.operating-system.version) ENDSWITH ".5"`, true},
+		{`@status(device.model.family) CONTAINS "a"`, true},
+		{`@status(device.model.family) CONTAINS "z"`, false},
+		{`@status(passcode.is-present) == TRUE`, true},
+		{`@status(passcode.is-present) == YES`, true},
+		{`@status(passcode.is-compliant) == false`, true},
+		{`@status(passcode.is-compliant) == NO`, true},
+		{`@status(test.missing) == nil`, true},
+		{`@status(test.missing) == NULL`, true},
+		{`@status(device.model.family) == nil`, false},
+		{`TRUEPREDICATE`, true},
+		{`FALSEPREDICATE`, false},
+
+		// quoting
+		{`@status(device.model.family) == 'Mac'`, true},
+		{`@status(device.model.family) == "AND"`, false},
+		{`"a b" == 'a b'`, true},
+		{`"it's" == "it's"`, true},
+		{`"AND" == "AND"`, true},
+
+		// boolean operators and precedence
+		{`TRUEPREDICATE AND FALSEPREDICATE`, false},
+		{`TRUEPREDICATE && TRUEPREDICATE`, true},
+		{`FALSEPREDICATE OR TRUEPREDICATE`, true},
+		{`FALSEPREDICATE || FALSEPREDICATE`, false},
+		{`NOT FALSEPREDICATE`, true},
+		{`! TRUEPREDICATE`, false},
+		{`not not TRUEPREDICATE`, true},
+		{`TRUEPREDICATE OR TRUEPREDICATE AND FALSEPREDICATE`, true},
+		{`(TRUEPREDICATE OR TRUEPREDICATE) AND FALSEPREDICATE`, false},
+		{`FALSEPREDICATE AND FALSEPREDICATE OR TRUEPREDICATE`, true},
+		{`FALSEPREDICATE AND (FALSEPREDICATE OR TRUEPREDICATE)`, false},
+		{`NOT TRUEPREDICATE OR TRUEPREDICATE`, true},
+		{`NOT (TRUEPREDICATE OR TRUEPREDICATE)`, false},
+		{`@status(device.model.family) == "Mac" AND @status(test.number) > 2`, true},
+		{`(@status(device.model.family) == "iPhone") OR (@status(passcode.is-present) == TRUE)`, true},
+
+		// IN lists
+		{`@status(device.model.family) IN {"iPhone", "Mac"}`, true},
+		{`@status(device.model.family) in {'iPad','iPhone'}`, false},
+		{`@status(test.number) IN {1, 2, 3}`, true},
+		{`@status(test.number) IN {}`, false},
+		{`NOT @status(device.model.family) IN {"iPhone"}`, true},
+	} {
+		have, err := evalPredicate(test.predicate, status)
+		if err != nil {
+			t.Errorf("%s: %v", test.predicate, err)
+			continue
+		}
+		if have != test.want {
+			t.Errorf("%s: have %v, want %v", test.predicate, have, test.want)
+		}
+	}
+}
+
+func TestEvalPredicateInvalid(t *testing.T) {
+	for _, predicate := range []string{
+		``,
+		`@status(device.model.family) == "Mac`,
+		`@status(device.model.family) == 'Mac"`,
+		`@status(device.model.family)`,
+		`@status(device.model.family) ==`,
+		`@status(device.model.family) LIKE "Mac"`,
+		`@status(device.model.family == "Mac"`,
+		`@status device.model.family == "Mac"`,
+		`@status() == "Mac"`,
+		`(TRUEPREDICATE`,
+		`TRUEPREDICATE)`,
+		`TRUEPREDICATE FALSEPREDICATE`,
+		`TRUEPREDICATE AND`,
+		`NOT`,
+		`Mac == "Mac"`,
+		`@status(test.number) # 3`,
+		`@status(test.number) IN 3`,
+		`@status(test.number) IN {1, 2`,
+		`@status(test.number) IN {1 2}`,
+	} {
+		if _, err := evalPredicate(predicate, nil); err == nil {
+			t.Errorf("expected error evaluating %q", predicate)
+		}
+	}
+}
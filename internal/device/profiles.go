@@ -53,33 +53,93 @@ func (ps *ProfileStore) persistProfile(pb []byte, profileID string) error {
 func (ps *ProfileStore) removeProfile(profileID string) error {
 	key := fmt.Sprintf("%s_%s", ps.ID, profileID)
 	return ps.DB.Update(func(tx *bolt.Tx) error {
+		err := BucketPutOrDelete(tx, "profiles_installed_by_mdm", key, nil)
+		if err != nil {
+			return err
+		}
 		return BucketPutOrDelete(tx, "profiles", key, nil)
 	})
 }
 
+// How a profile was installed
+const (
+	installedByUser = "0"
+	installedByMDM  = "1" // InstallProfile command
+	installedByDDM  = "ddm"
+)
+
+func (ps *ProfileStore) setInstalledBy(profileID, installedBy string) error {
+	key := fmt.Sprintf("%s_%s", ps.ID, profileID)
+	return ps.DB.Update(func(tx *bolt.Tx) error {
+		return BucketPutOrDeleteString(tx, "profiles_installed_by_mdm", key, installedBy)
+	})
+}
+
+// installedBy returns how the profile was installed, if recorded.
+func (ps *ProfileStore) installedBy(profileID string) (installedBy string, err error) {
+	key := fmt.Sprintf("%s_%s", ps.ID, profileID)
+	err = ps.DB.View(func(tx *bolt.Tx) error {
+		installedBy = BucketGetString(tx, "profiles_installed_by_mdm", key)
+		return nil
+	})
+	return
+}
+
+// payloadRefKey is the key of a payload reference. References are
+// scoped by the profile store so devices that install the same profile
+// don't overwrite each other's references.
+func (ps *ProfileStore) payloadRefKey(profileID string, pld *cfgprofiles.Payload, ekey string) string {
+	return fmt.Sprintf("%s_%s", ps.ID, legacyPayloadRefKey(profileID, pld, ekey))
+}
+
+// legacyPayloadRefKey is the key of payload references saved before
+// they were scoped by the profile store.
+func legacyPayloadRefKey(profileID string, pld *cfgprofiles.Payload, ekey string) string {
+	return fmt.Sprintf("%s_%s_%s_%s", profileID, pld.PayloadIdentifier, pld.PayloadUUID, ekey)
+}
+
 func (ps *ProfileStore) savePayloadRefString(profileID string, pld *cfgprofiles.Payload, ekey, value string) error {
 	if value == "" {
 		return errors.New("no payload ref value to save")
 	}
 	return ps.DB.Update(func(tx *bolt.Tx) error {
-		key := fmt.Sprintf("%s_%s_%s_%s", profileID, pld.PayloadIdentifier, pld.PayloadUUID, ekey)
+		key := ps.payloadRefKey(profileID, pld, ekey)
 		return BucketPutOrDeleteString(tx, "profile_payload_refs", key, value)
 	})
 }
 
 func (ps *ProfileStore) loadPayloadRefString(profileID string, pld *cfgprofiles.Payload, ekey string) (s string, err error) {
 	err = ps.DB.View(func(tx *bolt.Tx) error {
-		key := fmt.Sprintf("%s_%s_%s_%s", profileID, pld.PayloadIdentifier, pld.PayloadUUID, ekey)
-		s = BucketGetString(tx, "profile_payload_refs", key)
+		s = BucketGetString(tx, "profile_payload_refs", ps.payloadRefKey(profileID, pld, ekey))
+		return nil
+	})
+	return
+}
+
+// loadLegacyPayloadRefString loads a payload reference saved before
+// they were scoped by the profile store. Any profile store that
+// installed the same profile may have saved it.
+func (ps *ProfileStore) loadLegacyPayloadRefString(profileID string, pld *cfgprofiles.Payload, ekey string) (s string, err error) {
+	err = ps.DB.View(func(tx *bolt.Tx) error {
+		s = BucketGetString(tx, "profile_payload_refs", legacyPayloadRefKey(profileID, pld, ekey))
 		return nil
 	})
 	return
 }
 
-func (ps *ProfileStore) removePayloadRefString(profileID string, pld *cfgprofiles.Payload, ekey string) error {
+// removePayloadRefString removes the payload reference with value,
+// either scoped by the profile store or saved before.
+func (ps *ProfileStore) removePayloadRefString(profileID string, pld *cfgprofiles.Payload, ekey, value string) error {
 	return ps.DB.Update(func(tx *bolt.Tx) error {
-		key := fmt.Sprintf("%s_%s_%s_%s", profileID, pld.PayloadIdentifier, pld.PayloadUUID, ekey)
-		return BucketPutOrDeleteString(tx, "profile_payload_refs", key, "")
+		for _, key := range []string{
+			ps.payloadRefKey(profileID, pld, ekey),
+			legacyPayloadRefKey(profileID, pld, ekey),
+		} {
+			if BucketGetString(tx, "profile_payload_refs", key) == value {
+				return BucketPutOrDeleteString(tx, "profile_payload_refs", key, "")
+			}
+		}
+		return nil
 	})
 }
 
@@ -187,14 +247,20 @@ func classifyAndSortProfilePayloads(p *cfgprofiles.Profile, ascending bool) []*p
 }
 
 func (device *Device) InstallProfile(ctx context.Context, pb []byte) error {
-	return device.installProfile(ctx, pb, false)
+	return device.installProfile(ctx, pb, installedByUser)
 }
 
 func (device *Device) installProfileFromMDM(ctx context.Context, pb []byte) error {
-	return device.installProfile(ctx, pb, true)
+	return device.installProfile(ctx, pb, installedByMDM)
+}
+
+// installProfileFromDDM installs a profile of a legacy profile
+// configuration. It can't be removed with the RemoveProfile command.
+func (device *Device) installProfileFromDDM(ctx context.Context, pb []byte) error {
+	return device.installProfile(ctx, pb, installedByDDM)
 }
 
-func (device *Device) installProfile(ctx context.Context, pb []byte, fromMDM bool) error {
+func (device *Device) installProfile(ctx context.Context, pb []byte, installedBy string) error {
 	if len(pb) == 0 {
 		return errors.New("empty profile")
 	}
@@ -203,7 +269,7 @@ func (device *Device) installProfile(ctx context.Context, pb []byte, fromMDM boo
 	if err != nil {
 		return err
 	}
-	err = device.ValidateProfileInstall(p, fromMDM)
+	err = device.ValidateProfileInstall(p, installedBy != installedByUser)
 	if err != nil {
 		return err
 	}
@@ -219,7 +285,7 @@ func (device *Device) installProfile(ctx context.Context, pb []byte, fromMDM boo
 	}
 	if matched != "" {
 		// remove the existing installed profile
-		device.RemoveProfile(matched)
+		device.RemoveProfile(ctx, matched)
 	}
 
 	orderedPayloads := classifyAndSortProfilePayloads(p, false)
@@ -257,7 +323,11 @@ func (device *Device) installProfile(ctx context.Context, pb []byte, fromMDM boo
 		}
 	}
 
-	return device.SystemProfileStore().persistProfile(pb, p.PayloadIdentifier)
+	err = device.SystemProfileStore().persistProfile(pb, p.PayloadIdentifier)
+	if err != nil {
+		return err
+	}
+	return device.SystemProfileStore().setInstalledBy(p.PayloadIdentifier, installedBy)
 }
 
 func (device *Device) installMDMPayload(ctx context.Context, mdmPayload *cfgprofiles.MDMPayload, profileID string) error {
@@ -293,6 +363,7 @@ func (device *Device) installSCEPPayload(ctx context.Context, profileID string,
 		scepPayload.PayloadContent.Challenge,
 		scepPayload.PayloadContent.Name,
 		scepPayload.PayloadContent.CAFingerprint,
+		device.httpClient,
 	)
 	if err != nil {
 		return "", err
@@ -300,6 +371,7 @@ func (device *Device) installSCEPPayload(ctx context.Context, profileID string,
 
 	kciKey := NewKeychainItem(device.SystemKeychain(), ClassKey)
 	kciKey.Key = key
+	kciKey.ProfileID = profileID
 	err = kciKey.Save()
 	if err != nil {
 		return "", err
@@ -307,6 +379,7 @@ func (device *Device) installSCEPPayload(ctx context.Context, profileID string,
 
 	kciCert := NewKeychainItem(device.SystemKeychain(), ClassCertificate)
 	kciCert.Certificate = cert
+	kciCert.ProfileID = profileID
 	err = kciCert.Save()
 	if err != nil {
 		return "", err
@@ -315,6 +388,7 @@ func (device *Device) installSCEPPayload(ctx context.Context, profileID string,
 	kciID := NewKeychainItem(device.SystemKeychain(), ClassIdentity)
 	kciID.IdentityKeyUUID = kciKey.UUID
 	kciID.IdentityCertificateUUID = kciCert.UUID
+	kciID.ProfileID = profileID
 	err = kciID.Save()
 	if err != nil {
 		return "", err
@@ -328,11 +402,19 @@ func (device *Device) installSCEPPayload(ctx context.Context, profileID string,
 	return kciID.UUID, nil
 }
 
-func (device *Device) RemoveProfile(profileID string) error {
+func (device *Device) RemoveProfile(ctx context.Context, profileID string) error {
 	p, err := device.SystemProfileStore().Load(profileID)
 	if err != nil {
 		return err
 	}
+	if profileID == device.MDMProfileIdentifier {
+		// like a real device remove the profiles installed by MDM
+		// along with the enrollment profile
+		err = device.removeProfilesInstalledByMDM(ctx)
+		if err != nil {
+			return err
+		}
+	}
 	orderedPayloads := classifyAndSortProfilePayloads(p, true)
 
 	for _, pr := range orderedPayloads {
@@ -343,7 +425,7 @@ func (device *Device) RemoveProfile(profileID string) error {
 				fmt.Println(err)
 			}
 		case *cfgprofiles.MDMPayload:
-			err = device.removeMDMPayload()
+			err = device.removeMDMPayload(ctx)
 			if err != nil {
 				fmt.Println(err)
 			}
@@ -355,8 +437,90 @@ func (device *Device) RemoveProfile(profileID string) error {
 	return device.SystemProfileStore().removeProfile(p.PayloadIdentifier)
 }
 
+// removeProfilesInstalledByMDM removes the profiles installed by MDM
+// commands and by DDM configurations.
+func (device *Device) removeProfilesInstalledByMDM(ctx context.Context) error {
+	ids, err := device.SystemProfileStore().ListUUIDs()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if id == device.MDMProfileIdentifier {
+			// being removed by our caller (even if re-installed by MDM)
+			continue
+		}
+		installedBy, err := device.profileInstalledBy(id)
+		if err != nil {
+			return err
+		}
+		if installedBy == installedByUser {
+			continue
+		}
+		err = device.RemoveProfile(ctx, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// profileInstalledBy returns how the profile with profileID was
+// installed. Profiles installed before this was recorded are assumed to
+// be installed by MDM if the device is enrolled and they are not the
+// enrollment profile.
+func (device *Device) profileInstalledBy(profileID string) (string, error) {
+	installedBy, err := device.SystemProfileStore().installedBy(profileID)
+	if err != nil || installedBy != "" {
+		return installedBy, err
+	}
+	if device.MDMProfileIdentifier != "" && profileID != device.MDMProfileIdentifier {
+		return installedByMDM, nil
+	}
+	return installedByUser, nil
+}
+
+// InstalledByMDM reports whether the profile with profileID was
+// installed by an MDM command.
+func (device *Device) InstalledByMDM(profileID string) (bool, error) {
+	installedBy, err := device.profileInstalledBy(profileID)
+	return installedBy == installedByMDM, err
+}
+
+// isManagedProfile reports whether the profile with profileID was
+// installed by MDM or DDM (including the enrollment profile itself).
+func (device *Device) isManagedProfile(profileID string) (bool, error) {
+	if profileID == "" {
+		return false, nil
+	}
+	if profileID == device.MDMProfileIdentifier {
+		return true, nil
+	}
+	installedBy, err := device.profileInstalledBy(profileID)
+	return installedBy != installedByUser, err
+}
+
+// loadSCEPPayloadIdentityRef loads the UUID of the identity keychain
+// item installed by the SCEP payload.
+func (device *Device) loadSCEPPayloadIdentityRef(profileID string, scepPayload *cfgprofiles.SCEPPayload) (string, error) {
+	ps := device.SystemProfileStore()
+	refStr, err := ps.loadPayloadRefString(profileID, &scepPayload.Payload, "keychain_identity")
+	if err != nil || refStr != "" {
+		return refStr, err
+	}
+	refStr, err = ps.loadLegacyPayloadRefString(profileID, &scepPayload.Payload, "keychain_identity")
+	if err != nil || refStr == "" {
+		return "", err
+	}
+	// legacy references may have been saved by another device
+	// installing the same profile: only use our own
+	if _, err = LoadKeychainItem(device.SystemKeychain(), refStr); err != nil {
+		return "", nil
+	}
+	return refStr, nil
+}
+
 func (device *Device) removeSCEPPayload(profileID string, scepPayload *cfgprofiles.SCEPPayload) error {
-	refStr, err := device.SystemProfileStore().loadPayloadRefString(profileID, &scepPayload.Payload, "keychain_identity")
+	refStr, err := device.loadSCEPPayloadIdentityRef(profileID, scepPayload)
 	if err != nil {
 		return err
 	}
@@ -391,7 +555,7 @@ func (device *Device) removeSCEPPayload(profileID string, scepPayload *cfgprofil
 		return err
 	}
 
-	err = device.SystemProfileStore().removePayloadRefString(profileID, &scepPayload.Payload, "keychain_identity")
+	err = device.SystemProfileStore().removePayloadRefString(profileID, &scepPayload.Payload, "keychain_identity", refStr)
 	if err != nil {
 		return err
 	}
@@ -399,15 +563,12 @@ func (device *Device) removeSCEPPayload(profileID string, scepPayload *cfgprofil
 	return nil
 }
 
-func (device *Device) removeMDMPayload() error {
+func (device *Device) removeMDMPayload(ctx context.Context) error {
 	c, err := device.MDMClient()
 	if err != nil {
 		return err
 	}
-	err = c.unenroll()
-	if err != nil {
-		return err
-	}
+	err = c.unenroll(ctx)
 	device.Save()
-	return nil
+	return err
 }
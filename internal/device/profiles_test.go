@@ -0,0 +1,120 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jessepeterson/cfgprofiles"
+	bolt "go.etcd.io/bbolt"
+)
+
+func openTestDB(t *testing.T) *bolt.DB {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "mdmb.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestLegacyPayloadRef(t *testing.T) {
+	db := openTestDB(t)
+	devA, devB := New("a", db), New("b", db)
+	pld := cfgprofiles.NewSCEPPayload("com.example.scep")
+
+	// a reference to an identity of device B saved before references
+	// were scoped by profile store
+	kci := NewKeychainItem(devB.SystemKeychain(), ClassIdentity)
+	kci.IdentityKeyUUID, kci.IdentityCertificateUUID = "KEY", "CERT"
+	if err := kci.Save(); err != nil {
+		t.Fatal(err)
+	}
+	key := legacyPayloadRefKey("com.example", &pld.Payload, "keychain_identity")
+	err := db.Update(func(tx *bolt.Tx) error {
+		return BucketPutOrDeleteString(tx, "profile_payload_refs", key, kci.UUID)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyRef := func() (ref string) {
+		db.View(func(tx *bolt.Tx) error {
+			ref = BucketGetString(tx, "profile_payload_refs", key)
+			return nil
+		})
+		return
+	}
+
+	ref, err := devA.loadSCEPPayloadIdentityRef("com.example", pld)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref != "" {
+		t.Errorf("device A: have ref %q, want none", ref)
+	}
+	if err = devA.removeSCEPPayload("com.example", pld); err == nil {
+		t.Error("device A: expected error removing SCEP payload")
+	}
+	if legacyRef() != kci.UUID {
+		t.Fatal("device A removed the legacy ref of device B")
+	}
+
+	ref, err = devB.loadSCEPPayloadIdentityRef("com.example", pld)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := ref, kci.UUID; have != want {
+		t.Fatalf("device B: have ref %q, want %q", have, want)
+	}
+	err = devB.SystemProfileStore().removePayloadRefString("com.example", &pld.Payload, "keychain_identity", ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if legacyRef() != "" {
+		t.Error("device B: legacy ref not removed")
+	}
+}
+
+func testProfile(identifier string) []byte {
+	return []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0"><dict>
+<key>PayloadContent</key><array></array>
+<key>PayloadIdentifier</key><string>%s</string>
+<key>PayloadType</key><string>Configuration</string>
+<key>PayloadUUID</key><string>%s-UUID</string>
+<key>PayloadVersion</key><integer>1</integer>
+</dict></plist>`, identifier, identifier))
+}
+
+func TestRemoveEnrollmentProfileInstalledByMDM(t *testing.T) {
+	dev := New("test", openTestDB(t))
+	dev.MDMProfileIdentifier = "com.example.enroll"
+	ps := dev.SystemProfileStore()
+	for _, p := range []struct{ id, installedBy string }{
+		// e.g. re-delivered with the InstallProfile command
+		{"com.example.enroll", installedByMDM},
+		{"com.example.mdm", installedByMDM},
+		{"com.example.ddm", installedByDDM},
+		{"com.example.user", installedByUser},
+	} {
+		if err := ps.persistProfile(testProfile(p.id), p.id); err != nil {
+			t.Fatal(err)
+		}
+		if err := ps.setInstalledBy(p.id, p.installedBy); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := dev.RemoveProfile(context.Background(), "com.example.enroll"); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := ps.ListUUIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := ids, []string{"com.example.user"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("remaining profiles: have %v, want %v", have, want)
+	}
+}
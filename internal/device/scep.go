@@ -201,16 +201,24 @@ func scepCertsSelector(fingerprint []byte) (scep.CertsSelector, error) {
 	return scep.FingerprintCertsSelector(hashType, fingerprint), nil
 }
 
-func scepNewPKCSReq(ctx context.Context, csrBytes []byte, url, _, caMessage string, fingerprint []byte) (*x509.Certificate, error) {
-	selector, err := scepCertsSelector(fingerprint)
+func scepNewPKCSReq(ctx context.Context, csrBytes []byte, url, _, caMessage string, fingerprint []byte, doer mdmbscepclient.Doer) (*x509.Certificate, error) {
+	// as with FullSign all CA certificates are PKIOperation recipients
+	// so the selector only validates the fingerprint for now
+	_, err := scepCertsSelector(fingerprint)
 	if err != nil {
 		return nil, fmt.Errorf("scep cert selector: %w", err)
 	}
 
-	c, err := mdmbscepclient.New(url, mdmbscepclient.WithSignerKeypair(func(context.Context) (*x509.Certificate, *rsa.PrivateKey, error) {
-		key, cert, err := selfSign()
-		return cert, key, err
-	}))
+	opts := []mdmbscepclient.Option{
+		mdmbscepclient.WithSignerKeypair(func(context.Context) (*x509.Certificate, *rsa.PrivateKey, error) {
+			key, cert, err := selfSign()
+			return cert, key, err
+		}),
+	}
+	if doer != nil {
+		opts = append(opts, mdmbscepclient.WithClient(doer))
+	}
+	c, err := mdmbscepclient.New(url, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("creating scep client: %w", err)
 	}
@@ -221,10 +229,45 @@ func scepNewPKCSReq(ctx context.Context, csrBytes []byte, url, _, caMessage stri
 		return nil, fmt.Errorf("parsing csr: %w", err)
 	}
 
-	cert, err := c.FullSign(ctx, csr, []byte(caMessage), selector)
+	// perform the individual SCEP operations (rather than FullSign) so
+	// that each can be traced
+	err = traceSCEPOperation(ctx, "GetCACaps", func(ctx context.Context) error {
+		_, err := c.GetCACaps(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scep: error GetCACaps: %w", err)
+	}
+
+	err = traceSCEPOperation(ctx, "GetCACert", func(ctx context.Context) error {
+		_, err := c.GetCACert(ctx, []byte(caMessage))
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("scep: %w", err)
+		return nil, fmt.Errorf("scep: error GetCACert: %w", err)
+	}
+
+	var cert *x509.Certificate
+	err = traceSCEPOperation(ctx, "PKIOperation", func(ctx context.Context) error {
+		cert, err = c.Sign(ctx, csr, nil)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scep: error PKIOperation: %w", err)
 	}
 
 	return cert, nil
 }
+
+// traceSCEPOperation calls fn tracing it as the SCEP operation op.
+// fn should use the provided context for HTTP timing.
+func traceSCEPOperation(ctx context.Context, op string, fn func(context.Context) error) error {
+	started := time.Now()
+	tctx, timer := withHTTPTimer(ctx)
+	err := fn(tctx)
+	if err == nil {
+		timer.done(ctx, op)
+	}
+	contextTrace(ctx).scepOperation(op, time.Since(started), err)
+	return err
+}
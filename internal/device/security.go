@@ -0,0 +1,88 @@
+package device
+
+import (
+	bolt "go.etcd.io/bbolt"
+)
+
+// Hardware encryption capabilities
+const (
+	EncryptionBlockLevel = 1 << iota
+	EncryptionFileLevel
+)
+
+// Security is the security posture of a device reported in SecurityInfo
+// responses.
+type Security struct {
+	PasscodePresent               bool
+	PasscodeCompliant             bool
+	PasscodeCompliantWithProfiles bool
+
+	FileVaultEnabled bool
+	SIPEnabled       bool
+
+	FirewallEnabled          bool
+	FirewallBlockAllIncoming bool
+	FirewallStealthMode      bool
+
+	SecureBootLevel        string // "full", "medium", or "off"
+	HardwareEncryptionCaps int
+}
+
+// defaultSecurity is the (compliant) posture of new devices.
+func defaultSecurity() Security {
+	return Security{
+		PasscodePresent:               true,
+		PasscodeCompliant:             true,
+		PasscodeCompliantWithProfiles: true,
+		FileVaultEnabled:              true,
+		SIPEnabled:                    true,
+		FirewallEnabled:               true,
+		SecureBootLevel:               "full",
+		HardwareEncryptionCaps:        EncryptionBlockLevel | EncryptionFileLevel,
+	}
+}
+
+// saveSecurity saves the device security posture.
+func (device *Device) saveSecurity(tx *bolt.Tx) error {
+	sec := &device.Security
+	for _, v := range []struct {
+		bucket string
+		value  bool
+	}{
+		{"device_passcode_present", sec.PasscodePresent},
+		{"device_passcode_compliant", sec.PasscodeCompliant},
+		{"device_passcode_compliant_profiles", sec.PasscodeCompliantWithProfiles},
+		{"device_filevault", sec.FileVaultEnabled},
+		{"device_sip", sec.SIPEnabled},
+		{"device_firewall", sec.FirewallEnabled},
+		{"device_firewall_block_all", sec.FirewallBlockAllIncoming},
+		{"device_firewall_stealth", sec.FirewallStealthMode},
+	} {
+		err := BucketPutOrDeleteBool(tx, v.bucket, device.UDID, v.value)
+		if err != nil {
+			return err
+		}
+	}
+	err := BucketPutOrDeleteString(tx, "device_secure_boot_level", device.UDID, sec.SecureBootLevel)
+	if err != nil {
+		return err
+	}
+	return BucketPutOrDeleteInt(tx, "device_encryption_caps", device.UDID, sec.HardwareEncryptionCaps)
+}
+
+// loadSecurity loads the device security posture. It reports whether
+// the posture was found.
+func (device *Device) loadSecurity(tx *bolt.Tx) bool {
+	sec := &device.Security
+	sec.PasscodePresent = BucketGetBool(tx, "device_passcode_present", device.UDID)
+	sec.PasscodeCompliant = BucketGetBool(tx, "device_passcode_compliant", device.UDID)
+	sec.PasscodeCompliantWithProfiles = BucketGetBool(tx, "device_passcode_compliant_profiles", device.UDID)
+	sec.FileVaultEnabled = BucketGetBool(tx, "device_filevault", device.UDID)
+	sec.SIPEnabled = BucketGetBool(tx, "device_sip", device.UDID)
+	sec.FirewallEnabled = BucketGetBool(tx, "device_firewall", device.UDID)
+	sec.FirewallBlockAllIncoming = BucketGetBool(tx, "device_firewall_block_all", device.UDID)
+	sec.FirewallStealthMode = BucketGetBool(tx, "device_firewall_stealth", device.UDID)
+	sec.SecureBootLevel = BucketGetString(tx, "device_secure_boot_level", device.UDID)
+	sec.HardwareEncryptionCaps = BucketGetInt(tx, "device_encryption_caps", device.UDID)
+	return *sec != Security{}
+}
@@ -1,11 +1,16 @@
 package device
 
 import (
+	"encoding/hex"
 	"errors"
+	"strings"
 
 	bolt "go.etcd.io/bbolt"
 )
 
+// ErrPushTokenNotFound is returned when no device has registered a push token.
+var ErrPushTokenNotFound = errors.New("push token not found")
+
 func (device *Device) validDevice() bool {
 	return device.UDID != ""
 }
@@ -40,13 +45,114 @@ func (device *Device) Save() error {
 		if err != nil {
 			return err
 		}
-		return BucketPutOrDeleteString(tx, "device_product_name", device.UDID, device.ProductName)
+		err = BucketPutOrDeleteString(tx, "device_product_name", device.UDID, device.ProductName)
+		if err != nil {
+			return err
+		}
+		err = BucketPutOrDeleteString(tx, "device_push_magic", device.UDID, device.PushMagic)
+		if err != nil {
+			return err
+		}
+		err = BucketPutOrDeleteString(tx, "device_ddm_declarations_token", device.UDID, device.DeclarationsToken)
+		if err != nil {
+			return err
+		}
+		err = device.saveHardware(tx)
+		if err != nil {
+			return err
+		}
+		err = device.saveSecurity(tx)
+		if err != nil {
+			return err
+		}
+		return device.savePushToken(tx)
 	})
 }
 
+// savePushToken saves the device push token and maintains the reverse
+// index of push tokens to device UDIDs.
+func (device *Device) savePushToken(tx *bolt.Tx) error {
+	token := hex.EncodeToString(device.PushToken)
+	oldToken := BucketGetString(tx, "device_push_token", device.UDID)
+	if oldToken != "" && oldToken != token {
+		err := BucketPutOrDeleteString(tx, "push_token_device", oldToken, "")
+		if err != nil {
+			return err
+		}
+	}
+	err := BucketPutOrDeleteString(tx, "device_push_token", device.UDID, token)
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		return nil
+	}
+	return BucketPutOrDeleteString(tx, "push_token_device", token, device.UDID)
+}
+
+// saveHardware saves the device hardware attributes.
+func (device *Device) saveHardware(tx *bolt.Tx) error {
+	hw := &device.Hardware
+	err := BucketPutOrDeleteString(tx, "device_model", device.UDID, hw.Model)
+	if err != nil {
+		return err
+	}
+	err = BucketPutOrDeleteFloat(tx, "device_capacity", device.UDID, hw.DeviceCapacity)
+	if err != nil {
+		return err
+	}
+	err = BucketPutOrDeleteFloat(tx, "device_available_capacity", device.UDID, hw.AvailableDeviceCapacity)
+	if err != nil {
+		return err
+	}
+	err = BucketPutOrDeleteFloat(tx, "device_battery_level", device.UDID, hw.BatteryLevel)
+	if err != nil {
+		return err
+	}
+	err = BucketPutOrDeleteString(tx, "device_wifi_mac", device.UDID, hw.WiFiMAC)
+	if err != nil {
+		return err
+	}
+	err = BucketPutOrDeleteString(tx, "device_bluetooth_mac", device.UDID, hw.BluetoothMAC)
+	if err != nil {
+		return err
+	}
+	err = BucketPutOrDeleteString(tx, "device_ethernet_macs", device.UDID, strings.Join(hw.EthernetMACs, ","))
+	if err != nil {
+		return err
+	}
+	err = BucketPutOrDeleteBool(tx, "device_supervised", device.UDID, hw.IsSupervised)
+	if err != nil {
+		return err
+	}
+	return BucketPutOrDeleteBool(tx, "device_activation_lock", device.UDID, hw.IsActivationLockEnabled)
+}
+
+// loadHardware loads the device hardware attributes. It reports
+// whether the attributes were found.
+func (device *Device) loadHardware(tx *bolt.Tx) bool {
+	hw := &device.Hardware
+	hw.Model = BucketGetString(tx, "device_model", device.UDID)
+	hw.DeviceCapacity = BucketGetFloat(tx, "device_capacity", device.UDID)
+	hw.AvailableDeviceCapacity = BucketGetFloat(tx, "device_available_capacity", device.UDID)
+	hw.BatteryLevel = BucketGetFloat(tx, "device_battery_level", device.UDID)
+	hw.WiFiMAC = BucketGetString(tx, "device_wifi_mac", device.UDID)
+	hw.BluetoothMAC = BucketGetString(tx, "device_bluetooth_mac", device.UDID)
+	if macs := BucketGetString(tx, "device_ethernet_macs", device.UDID); macs != "" {
+		hw.EthernetMACs = strings.Split(macs, ",")
+	}
+	hw.IsSupervised = BucketGetBool(tx, "device_supervised", device.UDID)
+	hw.IsActivationLockEnabled = BucketGetBool(tx, "device_activation_lock", device.UDID)
+	return hw.Model != ""
+}
+
 // Load a device from bolt DB storage
-func Load(udid string, db *bolt.DB) (device *Device, err error) {
+func Load(udid string, db *bolt.DB, opts ...Option) (device *Device, err error) {
 	device = &Device{UDID: udid, boltDB: db}
+	for _, opt := range opts {
+		opt(device)
+	}
+	var hwFound, secFound bool
 	err = db.View(func(tx *bolt.Tx) error {
 		device.Serial = BucketGetString(tx, "device_serial", udid)
 		if device.Serial == "" {
@@ -55,11 +161,55 @@ func Load(udid string, db *bolt.DB) (device *Device, err error) {
 		device.ComputerName = BucketGetString(tx, "device_computer_name", udid)
 		device.MDMIdentityKeychainUUID = BucketGetString(tx, "device_mdm_identity_keychain_uuid", udid)
 		device.MDMProfileIdentifier = BucketGetString(tx, "device_mdm_profile_id", udid)
-		return nil
+		device.BuildVersion = BucketGetString(tx, "device_build_version", udid)
+		device.OSVersion = BucketGetString(tx, "device_os_version", udid)
+		device.ProductName = BucketGetString(tx, "device_product_name", udid)
+		device.DeclarationsToken = BucketGetString(tx, "device_ddm_declarations_token", udid)
+		hwFound = device.loadHardware(tx)
+		secFound = device.loadSecurity(tx)
+		device.PushMagic = BucketGetString(tx, "device_push_magic", udid)
+		var err error
+		device.PushToken, err = hex.DecodeString(BucketGetString(tx, "device_push_token", udid))
+		return err
+	})
+	if err != nil || (hwFound && secFound) {
+		return
+	}
+	// devices created before hardware attributes or the security
+	// posture were stored
+	if !hwFound {
+		device.Hardware = randHardware()
+	}
+	if !secFound {
+		device.Security = defaultSecurity()
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		err := device.saveHardware(tx)
+		if err != nil {
+			return err
+		}
+		return device.saveSecurity(tx)
 	})
 	return
 }
 
+// LoadByPushToken loads the device that registered the hex-encoded
+// APNs push token from bolt DB storage.
+func LoadByPushToken(token string, db *bolt.DB, opts ...Option) (*Device, error) {
+	var udid string
+	err := db.View(func(tx *bolt.Tx) error {
+		udid = BucketGetString(tx, "push_token_device", strings.ToLower(token))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if udid == "" {
+		return nil, ErrPushTokenNotFound
+	}
+	return Load(udid, db, opts...)
+}
+
 // List devices in bolt DB storage
 func List(db *bolt.DB) (udids []string, err error) {
 	err = db.View(func(tx *bolt.Tx) error {
@@ -0,0 +1,103 @@
+package device
+
+import (
+	"context"
+	"time"
+)
+
+// Trace is a set of hooks to observe MDM client operations.
+// Any particular hook may be nil.
+type Trace struct {
+	// HTTPResponse is called with the HTTP status code of each MDM
+	// request. request is either "checkin" or "connect."
+	HTTPResponse func(request string, statusCode int)
+
+	// CommandHandled is called after each MDM command is handled with
+	// the command request type and the resulting status.
+	CommandHandled func(requestType, status string)
+
+	// CheckIn is called after each check-in request with the message
+	// type (e.g. "Authenticate"), the elapsed time, and any error.
+	CheckIn func(messageType string, d time.Duration, err error)
+
+	// SCEPOperation is called after each SCEP operation (GetCACaps,
+	// GetCACert, or PKIOperation) with the elapsed time and any error.
+	SCEPOperation func(operation string, d time.Duration, err error)
+
+	// HTTPTiming is called after each HTTP request that received a
+	// response with the timing breakdown of the request. request is
+	// "checkin," "connect," or the SCEP operation.
+	HTTPTiming func(request string, timing HTTPTiming)
+}
+
+type traceKey struct{}
+
+// WithTrace returns a new context based on ctx that calls the hooks in t.
+// Hooks of any trace already in ctx are called as well.
+func WithTrace(ctx context.Context, t *Trace) context.Context {
+	if old := contextTrace(ctx); old != nil {
+		t = t.compose(old)
+	}
+	return context.WithValue(ctx, traceKey{}, t)
+}
+
+func contextTrace(ctx context.Context) *Trace {
+	t, _ := ctx.Value(traceKey{}).(*Trace)
+	return t
+}
+
+// compose returns a new trace that calls the hooks of both t and old.
+func (t *Trace) compose(old *Trace) *Trace {
+	return &Trace{
+		HTTPResponse: func(request string, statusCode int) {
+			old.httpResponse(request, statusCode)
+			t.httpResponse(request, statusCode)
+		},
+		CommandHandled: func(requestType, status string) {
+			old.commandHandled(requestType, status)
+			t.commandHandled(requestType, status)
+		},
+		CheckIn: func(messageType string, d time.Duration, err error) {
+			old.checkIn(messageType, d, err)
+			t.checkIn(messageType, d, err)
+		},
+		SCEPOperation: func(operation string, d time.Duration, err error) {
+			old.scepOperation(operation, d, err)
+			t.scepOperation(operation, d, err)
+		},
+		HTTPTiming: func(request string, timing HTTPTiming) {
+			old.httpTiming(request, timing)
+			t.httpTiming(request, timing)
+		},
+	}
+}
+
+func (t *Trace) httpResponse(request string, statusCode int) {
+	if t != nil && t.HTTPResponse != nil {
+		t.HTTPResponse(request, statusCode)
+	}
+}
+
+func (t *Trace) commandHandled(requestType, status string) {
+	if t != nil && t.CommandHandled != nil {
+		t.CommandHandled(requestType, status)
+	}
+}
+
+func (t *Trace) checkIn(messageType string, d time.Duration, err error) {
+	if t != nil && t.CheckIn != nil {
+		t.CheckIn(messageType, d, err)
+	}
+}
+
+func (t *Trace) scepOperation(operation string, d time.Duration, err error) {
+	if t != nil && t.SCEPOperation != nil {
+		t.SCEPOperation(operation, d, err)
+	}
+}
+
+func (t *Trace) httpTiming(request string, timing HTTPTiming) {
+	if t != nil && t.HTTPTiming != nil {
+		t.HTTPTiming(request, timing)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"context"
 	"crypto"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
 	"errors"
@@ -27,6 +28,7 @@ const (
 var (
 	ErrMissingDeviceIdentity = errors.New("missing device identity")
 	ErrNilTransport          = errors.New("nil transport")
+	ErrUnsupportedClient     = errors.New("client certificate unsupported by HTTP client")
 )
 
 // Doer executes an HTTP request.
@@ -42,8 +44,10 @@ type Transport struct {
 	checkInURL  string
 	serverURL   string
 	signMessage bool
+	clientCert  bool
 	provider    IdentityProvider
 	doer        Doer
+	doerErr     error
 }
 
 type TransportOption func(*Transport)
@@ -78,6 +82,15 @@ func WithSignMessage() TransportOption {
 	}
 }
 
+// WithClientCertificate presents the identity as the TLS client
+// certificate (i.e. mTLS) rather than signing messages. The HTTP client
+// must be from NewClientCertificateClient.
+func WithClientCertificate() TransportOption {
+	return func(t *Transport) {
+		t.clientCert = true
+	}
+}
+
 func NewTransport(opts ...TransportOption) *Transport {
 	t := &Transport{
 		doer: http.DefaultClient,
@@ -85,9 +98,72 @@ func NewTransport(opts ...TransportOption) *Transport {
 	for _, opt := range opts {
 		opt(t)
 	}
+	if t.clientCert && !isClientCertificateClient(t.doer) {
+		t.doerErr = ErrUnsupportedClient
+	}
 	return t
 }
 
+// identityProviderKey is the request context key of the identity
+// provider of a client certificate transport.
+type identityProviderKey struct{}
+
+// NewClientCertificateClient returns a copy of client, with a copy of
+// its *http.Transport (or the default transport), that presents the
+// identity of the (client certificate) Transport of each request as
+// the TLS client certificate. Connections are bound to the client
+// certificate so use a client per identity (e.g. per device) and reuse
+// it to reuse connections.
+func NewClientCertificateClient(client *http.Client) (*http.Client, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	var transport *http.Transport
+	switch rt := client.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		transport = rt.Clone()
+	default:
+		return nil, ErrUnsupportedClient
+	}
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.GetClientCertificate = clientCertificate
+	newClient := *client
+	newClient.Transport = transport
+	return &newClient, nil
+}
+
+func isClientCertificateClient(doer Doer) bool {
+	client, ok := doer.(*http.Client)
+	if !ok {
+		return false
+	}
+	transport, ok := client.Transport.(*http.Transport)
+	return ok && transport.TLSClientConfig != nil && transport.TLSClientConfig.GetClientCertificate != nil
+}
+
+func clientCertificate(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	provider, _ := cri.Context().Value(identityProviderKey{}).(IdentityProvider)
+	if provider == nil {
+		return nil, ErrMissingDeviceIdentity
+	}
+	cert, key, err := provider(cri.Context())
+	if err != nil {
+		return nil, err
+	}
+	if cert == nil || key == nil {
+		return nil, ErrMissingDeviceIdentity
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{cert.Raw},
+		PrivateKey:  key,
+		Leaf:        cert,
+	}, nil
+}
+
 // SignMessage generates the CMS detached signature encoded as Base64.
 func (t *Transport) SignMessage(ctx context.Context, body []byte) (string, error) {
 	if t.provider == nil {
@@ -117,6 +193,9 @@ func (t *Transport) doRequest(ctx context.Context, body io.Reader, checkin bool)
 	if t == nil {
 		return nil, ErrNilTransport
 	}
+	if t.doerErr != nil {
+		return nil, t.doerErr
+	}
 	var bodyBuf *bytes.Buffer
 	if t.signMessage {
 		bodyBuf = &bytes.Buffer{}
@@ -126,6 +205,11 @@ func (t *Transport) doRequest(ctx context.Context, body io.Reader, checkin bool)
 		body = bodyBuf
 	}
 
+	if t.clientCert {
+		// for the client certificate of new connections
+		ctx = context.WithValue(ctx, identityProviderKey{}, t.provider)
+	}
+
 	url := t.serverURL
 	if checkin && t.checkInURL != "" {
 		url = t.checkInURL